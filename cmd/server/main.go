@@ -1,11 +1,19 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/GlazedCurd/PlataTest/internal/config"
 	"github.com/GlazedCurd/PlataTest/internal/db"
 	"github.com/GlazedCurd/PlataTest/internal/handler"
+	"github.com/GlazedCurd/PlataTest/internal/retry"
 	"github.com/gin-gonic/gin"
 
 	"go.uber.org/zap"
@@ -22,7 +30,48 @@ func main() {
 		log.Fatal("Database environment variables are not set")
 	}
 
+	readTimeout, err := config.Duration("HTTP_READ_TIMEOUT", 10*time.Second)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	readHeaderTimeout, err := config.Duration("HTTP_READ_HEADER_TIMEOUT", 5*time.Second)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	writeTimeout, err := config.Duration("HTTP_WRITE_TIMEOUT", 10*time.Second)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	idleTimeout, err := config.Duration("HTTP_IDLE_TIMEOUT", 60*time.Second)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	maxHeaderBytes, err := config.Int("HTTP_MAX_HEADER_BYTES", 64<<10)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	maxBodyBytes, err := config.Int("HTTP_MAX_BODY_BYTES", 64<<10)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	shutdownTimeout, err := config.Duration("SHUTDOWN_TIMEOUT", 30*time.Second)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	convertMaxQuoteAge, err := config.Duration("CONVERT_MAX_QUOTE_AGE", 24*time.Hour)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	r := gin.Default()
+	r.Use(handler.MaxBodySize(int64(maxBodyBytes)))
 
 	zapLogger, err := zap.NewProduction()
 	if err != nil {
@@ -35,8 +84,21 @@ func main() {
 		}
 	}()
 
+	dbConnectRetry, err := retry.FromEnv("DB_CONNECT_RETRY", retry.Policy{
+		InitialDelay: 500 * time.Millisecond,
+		MaxDelay:     5 * time.Second,
+		MaxElapsed:   30 * time.Second,
+		Jitter:       retry.JitterDecorrelated,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
 	// Initialize database connection
-	db, err := db.ConnectDB(databaseHost, databasePort, databaseUser, databasePassword, databaseName)
+	db, err := db.ConnectDB(ctx, databaseHost, databasePort, databaseUser, databasePassword, databaseName, dbConnectRetry)
 	if err != nil {
 		log.Fatalf("Establishing connection to database %s", err)
 	}
@@ -47,15 +109,39 @@ func main() {
 		}
 	}()
 
-	handler.SetupHandlers(r, db, zapLogger)
+	handler.SetupHandlers(r, db, handler.Config{ConvertMaxQuoteAge: convertMaxQuoteAge}, zapLogger)
 
 	// Start the HTTP server
-	servicePort := os.Getenv("SERVICE_PORT")
-	if servicePort == "" {
-		servicePort = ":8080" // Default port if not set
+	servicePort := config.String("SERVICE_PORT", "8080")
+	server := &http.Server{
+		Addr:              ":" + servicePort,
+		Handler:           r,
+		ReadTimeout:       readTimeout,
+		ReadHeaderTimeout: readHeaderTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+		MaxHeaderBytes:    maxHeaderBytes,
 	}
-	err = r.Run(":" + servicePort)
-	if err != nil {
-		log.Fatalf("Starting server %s", err)
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			zapLogger.Fatal("Starting server", zap.Error(err))
+		}
+		return
+	case <-ctx.Done():
+	}
+
+	// Drain in-flight requests before the deferred database close
+	zapLogger.Info("Shutting down server", zap.Duration("timeout", shutdownTimeout))
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		zapLogger.Error("Shutting down server", zap.Error(err))
 	}
 }
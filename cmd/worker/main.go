@@ -1,19 +1,50 @@
 package main
 
 import (
+	"context"
+	"expvar"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/GlazedCurd/PlataTest/internal/config"
 	"github.com/GlazedCurd/PlataTest/internal/db"
 	quotafetcher "github.com/GlazedCurd/PlataTest/internal/quotafetcher"
+	"github.com/GlazedCurd/PlataTest/internal/retry"
 	"github.com/GlazedCurd/PlataTest/internal/worker"
 	"go.uber.org/zap"
-	"golang.org/x/time/rate"
 )
 
+// deprecatedEnv maps legacy environment variables to the ones replacing them.
+var deprecatedEnv = map[string]string{
+	"RETRIES_NUM":       "FETCH_RETRY_MAX_ATTEMPTS",
+	"TASK_MAX_ATTEMPTS": "TASK_RETRY_MAX_ATTEMPTS",
+}
+
+// applyDeprecatedEnv copies legacy variables to their replacements, so only
+// the replacements are read afterwards. A replacement set explicitly wins.
+func applyDeprecatedEnv(logger *zap.Logger) {
+	for legacy, name := range deprecatedEnv {
+		value := os.Getenv(legacy)
+		if value == "" {
+			continue
+		}
+		if os.Getenv(name) != "" {
+			logger.Warn("Ignoring deprecated environment variable", zap.String("name", legacy), zap.String("replacement", name))
+			continue
+		}
+		logger.Warn("Using deprecated environment variable", zap.String("name", legacy), zap.String("replacement", name))
+		if err := os.Setenv(name, value); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
 func main() {
 	databaseHost := os.Getenv("DATABASE_HOST")
 	databasePort := os.Getenv("DATABASE_PORT")
@@ -36,7 +67,22 @@ func main() {
 		}
 	}()
 
-	db, err := db.ConnectDB(databaseHost, databasePort, databaseUser, databasePassword, databaseName)
+	applyDeprecatedEnv(zapLogger)
+
+	dbConnectRetry, err := retry.FromEnv("DB_CONNECT_RETRY", retry.Policy{
+		InitialDelay: 500 * time.Millisecond,
+		MaxDelay:     5 * time.Second,
+		MaxElapsed:   30 * time.Second,
+		Jitter:       retry.JitterDecorrelated,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	db, err := db.ConnectDB(ctx, databaseHost, databasePort, databaseUser, databasePassword, databaseName, dbConnectRetry)
 	if err != nil {
 		log.Fatalf("Establishing connection to database %s", err)
 	}
@@ -47,68 +93,156 @@ func main() {
 		}
 	}()
 
-	httpRequestTimeout := os.Getenv("HTTP_TIMEOUT")
-	if httpRequestTimeout == "" {
-		httpRequestTimeout = "10s"
+	httpRequestTimeoutDuration, err := config.Duration("HTTP_TIMEOUT", 10*time.Second)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	httpRequestTimeoutDuration, err := time.ParseDuration(httpRequestTimeout)
+	workerIterationDuration, err := config.Duration("WORKER_ITERATION", 30*time.Second)
 	if err != nil {
-		log.Fatalf("Invalid HTTP_TIMEOUT duration %s", err)
+		log.Fatal(err)
 	}
 
-	workerIteration := os.Getenv("WORKER_ITERATION")
-	if workerIteration == "" {
-		workerIteration = "30s"
+	leaseDuration, err := config.Duration("LEASE_DURATION", 2*time.Minute)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	workerIterationDuration, err := time.ParseDuration(workerIteration)
+	reapInterval, err := config.Duration("REAP_INTERVAL", time.Minute)
 	if err != nil {
-		log.Fatalf("Invalid WORKER_ITERATION duration %s", err)
+		log.Fatal(err)
 	}
 
-	rateLimit := os.Getenv("RATE_LIMIT")
-	if rateLimit == "" {
-		rateLimit = "1"
+	rateLimitInt, err := config.Int("RATE_LIMIT", 1)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	rateLimitInt, err := strconv.Atoi(rateLimit)
+	numWorkersInt, err := config.Int("NUM_WORKERS", 5)
 	if err != nil {
-		log.Fatalf("Invalid RATE_LIMIT value %s", err)
+		log.Fatal(err)
 	}
 
-	numWorkers := os.Getenv("NUM_WORKERS")
-	if numWorkers == "" {
-		numWorkers = "5"
+	batchSize, err := config.Int("CLAIM_BATCH_SIZE", 100)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	numWorkersInt, err := strconv.Atoi(numWorkers)
+	taskRetry, err := retry.FromEnv("TASK_RETRY", retry.Policy{
+		MaxAttempts:  5,
+		InitialDelay: 30 * time.Second,
+		MaxDelay:     30 * time.Minute,
+		Jitter:       retry.JitterDecorrelated,
+	})
 	if err != nil {
-		log.Fatalf("Invalid NUM_WORKERS value %s", err)
+		log.Fatal(err)
+	}
+	if taskRetry.MaxAttempts < 0 {
+		log.Fatalf("TASK_RETRY_MAX_ATTEMPTS must not be negative, got %d", taskRetry.MaxAttempts)
 	}
 
-	retriesNum := os.Getenv("RETRIES_NUM")
-	if retriesNum == "" {
-		retriesNum = "5"
+	shutdownGracePeriod, err := config.Duration("SHUTDOWN_GRACE_PERIOD", 30*time.Second)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	retriesNumInt, err := strconv.Atoi(retriesNum)
+	fetchRetry, err := retry.FromEnv("FETCH_RETRY", retry.Policy{
+		MaxAttempts:  5,
+		InitialDelay: time.Second,
+		MaxDelay:     10 * time.Second,
+		Jitter:       retry.JitterDecorrelated,
+	})
 	if err != nil {
-		log.Fatalf("Invalid RETRIES_NUM value %s", err)
+		log.Fatal(err)
 	}
 
-	limiter := rate.NewLimiter(rate.Every(10*time.Second), rateLimitInt)
-	httpClient := &http.Client{
-		Timeout: httpRequestTimeoutDuration,
+	breakerFailureThreshold, err := config.Int("BREAKER_FAILURE_THRESHOLD", 5)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	breakerOpenTimeout, err := config.Duration("BREAKER_OPEN_TIMEOUT", time.Minute)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	catalogSyncInterval, err := config.Duration("CATALOG_SYNC_INTERVAL", 24*time.Hour)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	candleRollupPeriod, err := config.Duration("CANDLE_ROLLUP_PERIOD", 10*time.Minute)
+	if err != nil {
+		log.Fatal(err)
 	}
-	exchangeratesapiApiKey := os.Getenv("EXCHANGERATESAPI_API_KEY")
-	if exchangeratesapiApiKey == "" {
-		log.Fatal("EXCHANGERATESAPI_API_KEY environment variable is not set")
+
+	candleRollupIntervals, err := worker.ParseCandleIntervals(config.String("CANDLE_ROLLUP_INTERVALS", "1h,1d"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	workerId := os.Getenv("WORKER_ID")
+	if workerId == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			log.Fatalf("Getting hostname %s", err)
+		}
+		workerId = fmt.Sprintf("%s-%d", hostname, os.Getpid())
 	}
-	exchangeratesapiBaseUrl := os.Getenv("EXCHANGERATESAPI_BASE_URL")
-	if exchangeratesapiBaseUrl == "" {
-		log.Fatal("EXCHANGERATESAPI_BASE_URL environment variable is not set")
+
+	httpClient := &http.Client{
+		Timeout: httpRequestTimeoutDuration,
 	}
-	quotaFetcher := quotafetcher.NewExchangeratesQuotaFetcher(httpClient, limiter, exchangeratesapiApiKey, exchangeratesapiBaseUrl, retriesNumInt)
-	worker.NewWorker(db, workerIterationDuration, numWorkersInt, zapLogger, quotaFetcher).Start()
+	quotaFetcher, catalogSources, err := buildQuotaFetcher(providerDeps{
+		httpClient: httpClient,
+		rateLimit:  rateLimitInt,
+		retry:      fetchRetry,
+		breaker: quotafetcher.BreakerConfig{
+			FailureThreshold: breakerFailureThreshold,
+			OpenTimeout:      breakerOpenTimeout,
+		},
+		logger: zapLogger,
+	})
+	if err != nil {
+		log.Fatalf("Building quota fetcher %s", err)
+	}
+	workerConfig := worker.Config{
+		ID:                  workerId,
+		Tick:                workerIterationDuration,
+		NumWorkers:          numWorkersInt,
+		BatchSize:           batchSize,
+		LeaseDuration:       leaseDuration,
+		ReapInterval:        reapInterval,
+		Retry:               taskRetry,
+		ShutdownGracePeriod: shutdownGracePeriod,
+	}
+
+	// Circuit breaker states and other expvar metrics are served at /debug/vars
+	if metricsAddr := os.Getenv("METRICS_ADDR"); metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/debug/vars", expvar.Handler())
+		go func() {
+			err := http.ListenAndServe(metricsAddr, mux)
+			if err != nil {
+				zapLogger.Error("Serving metrics", zap.Error(err))
+			}
+		}()
+	}
+
+	// Everything using the database is waited for before the deferred close
+	var wg sync.WaitGroup
+	if catalogSyncInterval > 0 {
+		wg.Go(func() {
+			worker.NewCatalogSyncer(db, catalogSources, catalogSyncInterval, zapLogger).Run(ctx)
+		})
+	}
+	if candleRollupPeriod > 0 && len(candleRollupIntervals) > 0 {
+		wg.Go(func() {
+			worker.NewCandleRollup(db, candleRollupIntervals, candleRollupPeriod, zapLogger).Run(ctx)
+		})
+	}
+	wg.Go(func() {
+		worker.NewWorker(db, workerConfig, zapLogger, quotaFetcher).Start(ctx)
+	})
+	wg.Wait()
 }
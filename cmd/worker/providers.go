@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/GlazedCurd/PlataTest/internal/config"
+	quotafetcher "github.com/GlazedCurd/PlataTest/internal/quotafetcher"
+	"github.com/GlazedCurd/PlataTest/internal/retry"
+	"github.com/GlazedCurd/PlataTest/internal/worker"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// providerDeps holds settings shared by all quote providers.
+type providerDeps struct {
+	httpClient *http.Client
+	rateLimit  int
+	retry      retry.Policy
+	breaker    quotafetcher.BreakerConfig
+	logger     *zap.Logger
+}
+
+// buildQuotaFetcher combines the providers listed in QUOTE_PROVIDERS according
+// to QUOTE_STRATEGY: a failover chain asked in the listed order, or a consensus
+// of all of them. Each provider sits behind its own circuit breaker. Pairs no
+// provider quotes directly are triangulated through TRIANGULATION_HUBS.
+// Providers able to list their symbols are returned as catalog sources.
+func buildQuotaFetcher(deps providerDeps) (quotafetcher.BatchQuotaFetcher, []worker.CatalogSource, error) {
+	var providers []quotafetcher.Provider
+	var sources []worker.CatalogSource
+	for _, name := range strings.Split(config.String("QUOTE_PROVIDERS", "exchangeratesapi"), ",") {
+		name = strings.TrimSpace(name)
+		fetcher, err := newProvider(name, deps)
+		if err != nil {
+			return nil, nil, fmt.Errorf("provider %s: %w", name, err)
+		}
+		if lister, ok := fetcher.(quotafetcher.SymbolLister); ok {
+			sources = append(sources, worker.CatalogSource{Name: name, Lister: lister})
+		}
+		fetcher = quotafetcher.NewCircuitBreakerQuotaFetcher(name, fetcher, deps.breaker, deps.logger)
+		providers = append(providers, quotafetcher.Provider{Name: name, Fetcher: fetcher})
+	}
+	fetcher, err := combineProviders(config.String("QUOTE_STRATEGY", "failover"), providers)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hubs := config.String("TRIANGULATION_HUBS", "EUR,USD")
+	if hubs == "" {
+		return fetcher, sources, nil
+	}
+	return quotafetcher.NewTriangulatingQuotaFetcher(fetcher, strings.Split(hubs, ",")), sources, nil
+}
+
+func combineProviders(strategy string, providers []quotafetcher.Provider) (quotafetcher.BatchQuotaFetcher, error) {
+	switch strategy {
+	case "failover":
+		return quotafetcher.NewFailoverQuotaFetcher(providers), nil
+	case "consensus":
+		quorum, err := config.Int("CONSENSUS_QUORUM", 2)
+		if err != nil {
+			return nil, err
+		}
+		maxDeviation, err := config.Float("CONSENSUS_MAX_DEVIATION", 0.005)
+		if err != nil {
+			return nil, err
+		}
+		if quorum > len(providers) {
+			return nil, fmt.Errorf("CONSENSUS_QUORUM %d exceeds the number of providers %d", quorum, len(providers))
+		}
+		return quotafetcher.NewConsensusQuotaFetcher(providers, quotafetcher.ConsensusConfig{
+			Quorum:       quorum,
+			MaxDeviation: maxDeviation,
+		}), nil
+	default:
+		return nil, fmt.Errorf("unknown QUOTE_STRATEGY %s", strategy)
+	}
+}
+
+func newProvider(name string, deps providerDeps) (quotafetcher.BatchQuotaFetcher, error) {
+	switch name {
+	case "exchangeratesapi":
+		apiKey := os.Getenv("EXCHANGERATESAPI_API_KEY")
+		if apiKey == "" {
+			return nil, fmt.Errorf("EXCHANGERATESAPI_API_KEY environment variable is not set")
+		}
+		baseUrl := os.Getenv("EXCHANGERATESAPI_BASE_URL")
+		if baseUrl == "" {
+			return nil, fmt.Errorf("EXCHANGERATESAPI_BASE_URL environment variable is not set")
+		}
+		// The free plan quotes against EUR only
+		bases := strings.Split(config.String("EXCHANGERATESAPI_BASES", "EUR"), ",")
+		for i := range bases {
+			bases[i] = strings.TrimSpace(bases[i])
+		}
+		limiter := rate.NewLimiter(rate.Every(10*time.Second), deps.rateLimit)
+		return quotafetcher.NewExchangeratesQuotaFetcher(deps.httpClient, limiter, apiKey, baseUrl, bases, deps.retry), nil
+	case "ecb":
+		baseUrl := config.String("ECB_BASE_URL", "https://www.ecb.europa.eu/stats/eurofxref/")
+		limiter := rate.NewLimiter(rate.Every(time.Second), 1)
+		return quotafetcher.NewECBQuotaFetcher(deps.httpClient, limiter, baseUrl, deps.retry), nil
+	case "cbr":
+		baseUrl := config.String("CBR_BASE_URL", "https://www.cbr.ru/scripts/")
+		limiter := rate.NewLimiter(rate.Every(time.Second), 1)
+		return quotafetcher.NewCBRQuotaFetcher(deps.httpClient, limiter, baseUrl, deps.retry), nil
+	case "openexchangerates":
+		appId := os.Getenv("OPENEXCHANGERATES_APP_ID")
+		if appId == "" {
+			return nil, fmt.Errorf("OPENEXCHANGERATES_APP_ID environment variable is not set")
+		}
+		baseUrl := config.String("OPENEXCHANGERATES_BASE_URL", "https://openexchangerates.org/api/")
+		limiter := rate.NewLimiter(rate.Every(time.Second), deps.rateLimit)
+		return quotafetcher.NewOpenexchangeratesQuotaFetcher(deps.httpClient, limiter, appId, baseUrl, deps.retry), nil
+	default:
+		return nil, fmt.Errorf("unknown provider")
+	}
+}
@@ -6,7 +6,9 @@ require (
 	github.com/gin-gonic/gin v1.10.1
 	github.com/go-playground/assert/v2 v2.2.0
 	github.com/lib/pq v1.10.9
+	github.com/shopspring/decimal v1.4.0
 	go.uber.org/zap v1.27.0
+	golang.org/x/text v0.15.0
 	golang.org/x/time v0.12.0
 )
 
@@ -36,7 +38,6 @@ require (
 	golang.org/x/crypto v0.23.0 // indirect
 	golang.org/x/net v0.25.0 // indirect
 	golang.org/x/sys v0.20.0 // indirect
-	golang.org/x/text v0.15.0 // indirect
 	google.golang.org/protobuf v1.34.1 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
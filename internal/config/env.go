@@ -0,0 +1,56 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// String returns the value of the environment variable name or def if it is not set.
+func String(name, def string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	return value
+}
+
+// Duration parses the environment variable name as time.Duration, falling back to def.
+func Duration(name string, def time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s duration: %w", name, err)
+	}
+	return duration, nil
+}
+
+// Int parses the environment variable name as int, falling back to def.
+func Int(name string, def int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+	res, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s value: %w", name, err)
+	}
+	return res, nil
+}
+
+// Float parses the environment variable name as float64, falling back to def.
+func Float(name string, def float64) (float64, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+	res, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s value: %w", name, err)
+	}
+	return res, nil
+}
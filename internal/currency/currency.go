@@ -0,0 +1,104 @@
+// Package currency validates currency codes and pairs against the ISO 4217 list.
+package currency
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NoMinorUnits marks currencies without a minor unit, e.g. precious metals.
+const NoMinorUnits = -1
+
+// Currency is an entry of the ISO 4217 list.
+type Currency struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+	// MinorUnits is the number of decimal digits, or NoMinorUnits.
+	MinorUnits int `json:"minor_units"`
+}
+
+//go:embed iso4217.csv
+var iso4217 []byte
+
+var currencies = mustLoad(iso4217)
+
+func mustLoad(data []byte) map[string]Currency {
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		panic(fmt.Sprintf("parse ISO 4217 list: %s", err))
+	}
+	res := make(map[string]Currency, len(records))
+	// The first record is the header
+	for _, record := range records[1:] {
+		minorUnits := NoMinorUnits
+		if record[2] != "" {
+			minorUnits, err = strconv.Atoi(record[2])
+			if err != nil {
+				panic(fmt.Sprintf("parse minor units of %s: %s", record[0], err))
+			}
+		}
+		res[record[0]] = Currency{Code: record[0], Name: record[1], MinorUnits: minorUnits}
+	}
+	return res
+}
+
+// Lookup returns the currency with the given upper-case code.
+func Lookup(code string) (Currency, bool) {
+	currency, ok := currencies[code]
+	return currency, ok
+}
+
+// All returns every known currency ordered by code.
+func All() []Currency {
+	res := make([]Currency, 0, len(currencies))
+	for _, currency := range currencies {
+		res = append(res, currency)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Code < res[j].Code
+	})
+	return res
+}
+
+// ErrInvalidPair is returned for pairs that aren't two distinct ISO 4217 codes.
+var ErrInvalidPair = errors.New("invalid currency pair")
+
+// Pair is a validated currency pair: the price of one Base in Quote.
+type Pair struct {
+	Base  string
+	Quote string
+}
+
+// ParsePair accepts pairs in any case, separated by "_", "/", "-" or nothing,
+// e.g. "eurusd", "EUR/USD" and "eur-usd" all give EUR_USD.
+func ParsePair(s string) (Pair, error) {
+	normalized := strings.ToUpper(strings.TrimSpace(s))
+	var base, quote string
+	if i := strings.IndexAny(normalized, "_/-"); i >= 0 {
+		base, quote = normalized[:i], normalized[i+1:]
+	} else if len(normalized) == 6 {
+		base, quote = normalized[:3], normalized[3:]
+	} else {
+		return Pair{}, fmt.Errorf("%w %q: expected two currency codes like EUR_USD", ErrInvalidPair, s)
+	}
+	for _, code := range []string{base, quote} {
+		if _, ok := Lookup(code); !ok {
+			return Pair{}, fmt.Errorf("%w %q: unknown currency %q", ErrInvalidPair, s, code)
+		}
+	}
+	if base == quote {
+		return Pair{}, fmt.Errorf("%w %q: same currency on both sides", ErrInvalidPair, s)
+	}
+	return Pair{Base: base, Quote: quote}, nil
+}
+
+// String returns the canonical form of the pair, e.g. EUR_USD.
+func (p Pair) String() string {
+	return p.Base + "_" + p.Quote
+}
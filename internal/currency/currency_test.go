@@ -0,0 +1,74 @@
+package currency
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/shopspring/decimal"
+)
+
+func TestParsePair(t *testing.T) {
+	for _, s := range []string{"EUR_USD", "eurusd", "EUR/USD", "eur-usd", " Eur_Usd "} {
+		pair, err := ParsePair(s)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, pair.String(), "EUR_USD")
+	}
+}
+
+func TestParsePairInvalid(t *testing.T) {
+	for _, s := range []string{"", "foo", "EUR", "EUR_", "EUR_USDX", "EUR_ABC", "EURUSDGBP", "EUR_USD_GBP", "USD_USD"} {
+		_, err := ParsePair(s)
+		assert.Equal(t, errors.Is(err, ErrInvalidPair), true)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	jpy, ok := Lookup("JPY")
+	assert.Equal(t, ok, true)
+	assert.Equal(t, jpy.MinorUnits, 0)
+
+	gold, ok := Lookup("XAU")
+	assert.Equal(t, ok, true)
+	assert.Equal(t, gold.MinorUnits, NoMinorUnits)
+
+	_, ok = Lookup("usd")
+	assert.Equal(t, ok, false)
+}
+
+func TestRoundAmount(t *testing.T) {
+	usd, _ := Lookup("USD")
+	jpy, _ := Lookup("JPY")
+	gold, _ := Lookup("XAU")
+
+	tests := []struct {
+		currency Currency
+		amount   string
+		mode     RoundingMode
+		want     string
+	}{
+		{currency: usd, amount: "144.525", mode: RoundHalfEven, want: "144.52"},
+		{currency: usd, amount: "144.535", mode: RoundHalfEven, want: "144.54"},
+		{currency: usd, amount: "144.525", mode: RoundHalfUp, want: "144.53"},
+		{currency: usd, amount: "-144.525", mode: RoundHalfUp, want: "-144.53"},
+		{currency: usd, amount: "144.529", mode: RoundDown, want: "144.52"},
+		{currency: usd, amount: "144.521", mode: RoundUp, want: "144.53"},
+		{currency: usd, amount: "-144.521", mode: RoundFloor, want: "-144.53"},
+		{currency: usd, amount: "-144.529", mode: RoundCeiling, want: "-144.52"},
+		{currency: jpy, amount: "18250.5", mode: RoundHalfEven, want: "18250"},
+		{currency: gold, amount: "0.123456", mode: RoundHalfEven, want: "0.1235"},
+	}
+	for _, tt := range tests {
+		got := tt.currency.RoundAmount(decimal.RequireFromString(tt.amount), tt.mode, 4)
+		assert.Equal(t, got.String(), tt.want)
+	}
+}
+
+func TestParseRoundingMode(t *testing.T) {
+	mode, err := ParseRoundingMode("half_up")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, mode, RoundHalfUp)
+
+	_, err = ParseRoundingMode("HALF_UP")
+	assert.Equal(t, err != nil, true)
+}
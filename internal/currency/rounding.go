@@ -0,0 +1,63 @@
+package currency
+
+import (
+	"fmt"
+
+	"github.com/shopspring/decimal"
+)
+
+// RoundingMode tells how amounts are rounded to the minor unit.
+type RoundingMode string
+
+const (
+	// RoundHalfEven rounds halves to the even digit, the banker's rounding.
+	RoundHalfEven RoundingMode = "half_even"
+	// RoundHalfUp rounds halves away from zero.
+	RoundHalfUp RoundingMode = "half_up"
+	// RoundDown truncates towards zero.
+	RoundDown RoundingMode = "down"
+	// RoundUp rounds away from zero.
+	RoundUp RoundingMode = "up"
+	// RoundFloor rounds towards negative infinity.
+	RoundFloor RoundingMode = "floor"
+	// RoundCeiling rounds towards positive infinity.
+	RoundCeiling RoundingMode = "ceiling"
+)
+
+// ParseRoundingMode accepts the names of the RoundingMode constants.
+func ParseRoundingMode(s string) (RoundingMode, error) {
+	switch mode := RoundingMode(s); mode {
+	case RoundHalfEven, RoundHalfUp, RoundDown, RoundUp, RoundFloor, RoundCeiling:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown rounding mode %q", s)
+	}
+}
+
+// Round rounds amount to places decimal digits.
+func (m RoundingMode) Round(amount decimal.Decimal, places int32) decimal.Decimal {
+	switch m {
+	case RoundHalfUp:
+		return amount.Round(places)
+	case RoundDown:
+		return amount.RoundDown(places)
+	case RoundUp:
+		return amount.RoundUp(places)
+	case RoundFloor:
+		return amount.RoundFloor(places)
+	case RoundCeiling:
+		return amount.RoundCeil(places)
+	default:
+		return amount.RoundBank(places)
+	}
+}
+
+// RoundAmount rounds amount to the minor unit of the currency. Amounts of
+// currencies without a minor unit are rounded to maxPlaces digits.
+func (c Currency) RoundAmount(amount decimal.Decimal, mode RoundingMode, maxPlaces int32) decimal.Decimal {
+	places := maxPlaces
+	if c.MinorUnits != NoMinorUnits {
+		places = int32(c.MinorUnits)
+	}
+	return mode.Round(amount, places)
+}
@@ -0,0 +1,132 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/GlazedCurd/PlataTest/internal/model"
+)
+
+// candleBucket truncates updated_at to the start of its candle: $2 is the
+// date_trunc unit and $3 the number of units per candle, e.g. 13:45 falls
+// into the 12:00 candle of 4 hours.
+const candleBucket = `date_trunc($2, updated_at) - (mod(date_part($2, updated_at)::int, $3) || ' ' || $2)::interval`
+
+// candleAggregates computes a candle from the quotes of its partition of the
+// window candle. Every row of the partition gets the same values, so queries
+// keep one of them with DISTINCT ON.
+const candleAggregates = `first_value(quote) OVER candle,
+            max(quote) OVER candle,
+            min(quote) OVER candle,
+            last_value(quote) OVER candle,
+            count(*) OVER candle`
+
+// candleWindow orders the quotes of a candle for candleAggregates, the frame
+// spans the whole partition for last_value.
+const candleWindow = `ORDER BY updated_at, id ROWS BETWEEN UNBOUNDED PRECEDING AND UNBOUNDED FOLLOWING`
+
+// candlesLock names the advisory lock that lets one replica roll up candles
+// at a time. Lock keys are hashed from the name of the table they guard.
+const candlesLock = "quote_candles"
+
+func (d *dbImpl) GetCandles(ctx context.Context, query model.CandleQuery) ([]model.Candle, error) {
+	var candles []model.Candle
+	// Candles of the pair before its last rolled up one are final, the rest
+	// are aggregated from quotes
+	rows, err := d.database.QueryContext(ctx, `
+        WITH watermark AS (
+            SELECT coalesce(max(bucket), '-infinity'::timestamp) AS since
+            FROM quote_candles
+            WHERE code = $1 AND period = $4
+        )
+        SELECT bucket, open, high, low, close, count
+        FROM quote_candles
+        WHERE code = $1 AND period = $4 AND bucket >= $5 AND bucket < $6
+            AND bucket < (SELECT since FROM watermark)
+        UNION ALL
+        SELECT DISTINCT ON (bucket) bucket, `+candleAggregates+`
+        FROM (
+            SELECT `+candleBucket+` AS bucket, quote, updated_at, id
+            FROM quotes
+            WHERE code = $1 AND status = 'success' AND as_of IS NULL
+                AND updated_at >= greatest($5, (SELECT since FROM watermark))
+                AND updated_at < $6::timestamp + make_interval(secs => $7)
+        ) live
+        WHERE bucket >= $5 AND bucket < $6
+        WINDOW candle AS (PARTITION BY bucket `+candleWindow+`)
+        ORDER BY 1
+    `, query.Code, query.Interval.Unit, query.Interval.Count, query.Interval.Name,
+		query.From.UTC(), query.To.UTC(), query.Interval.Duration().Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("get candles: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var candle model.Candle
+		if err := rows.Scan(&candle.Time, &candle.Open, &candle.High, &candle.Low, &candle.Close, &candle.Count); err != nil {
+			return nil, fmt.Errorf("scan candle: %w", err)
+		}
+		candles = append(candles, candle)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("get candles rows: %w", err)
+	}
+
+	return candles, nil
+}
+
+func (d *dbImpl) RollupCandles(ctx context.Context, interval model.CandleInterval) (int64, error) {
+	tx, err := d.database.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin rollup candles: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var locked bool
+	if err := tx.QueryRowContext(ctx, `SELECT pg_try_advisory_xact_lock(hashtext($1))`, candlesLock).Scan(&locked); err != nil {
+		return 0, fmt.Errorf("lock rollup candles: %w", err)
+	}
+	if !locked {
+		return 0, nil
+	}
+
+	// The last stored candle may still have been open, and one more is
+	// recomputed for quotes committed late
+	res, err := tx.ExecContext(ctx, `
+        WITH watermark AS (
+            SELECT coalesce(max(bucket) - make_interval(secs => $4), '-infinity'::timestamp) AS since
+            FROM quote_candles
+            WHERE period = $1
+        )
+        INSERT INTO quote_candles (code, period, bucket, open, high, low, close, count)
+        SELECT DISTINCT ON (code, bucket) code, $1, bucket, `+candleAggregates+`
+        FROM (
+            SELECT code, `+candleBucket+` AS bucket, quote, updated_at, id
+            FROM quotes
+            WHERE status = 'success' AND as_of IS NULL
+                AND updated_at >= (SELECT since FROM watermark)
+        ) recent
+        WINDOW candle AS (PARTITION BY code, bucket `+candleWindow+`)
+        ON CONFLICT (code, period, bucket) DO UPDATE
+        SET open = EXCLUDED.open,
+            high = EXCLUDED.high,
+            low = EXCLUDED.low,
+            close = EXCLUDED.close,
+            count = EXCLUDED.count
+    `, interval.Name, interval.Unit, interval.Count, interval.Duration().Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("rollup candles: %w", err)
+	}
+	rolledUp, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("rollup candles rows: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit rollup candles: %w", err)
+	}
+	return rolledUp, nil
+}
@@ -0,0 +1,149 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/GlazedCurd/PlataTest/internal/model"
+	"github.com/lib/pq"
+)
+
+// currencyColumns is the column list every currency query returns, in scanCurrency order.
+const currencyColumns = `code, name, minor_units, base_providers, target_providers`
+
+// currenciesLock names the advisory lock that lets one replica replace the
+// catalog at a time. Lock keys are hashed from the name of the table they guard.
+const currenciesLock = "currencies"
+
+func scanCurrency(row rowScanner) (*model.Currency, error) {
+	var currency model.Currency
+	err := row.Scan(
+		&currency.Code,
+		&currency.Name,
+		&currency.MinorUnits,
+		pq.Array(&currency.BaseProviders),
+		pq.Array(&currency.TargetProviders),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &currency, nil
+}
+
+func (d *dbImpl) GetCurrencies(ctx context.Context) ([]model.Currency, error) {
+	var currencies []model.Currency
+	rows, err := d.database.QueryContext(ctx, `
+        SELECT `+currencyColumns+`
+        FROM currencies
+        ORDER BY code
+    `)
+	if err != nil {
+		return nil, fmt.Errorf("get currencies: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		currency, err := scanCurrency(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan currency: %w", err)
+		}
+		currencies = append(currencies, *currency)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("get currencies rows: %w", err)
+	}
+
+	return currencies, nil
+}
+
+func (d *dbImpl) GetCurrency(ctx context.Context, code string) (*model.Currency, error) {
+	currency, err := scanCurrency(d.database.QueryRowContext(ctx, `
+        SELECT `+currencyColumns+`
+        FROM currencies
+        WHERE code = $1
+    `, code))
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrorNotFound
+		}
+		return nil, fmt.Errorf("get currency: %w", err)
+	}
+
+	return currency, nil
+}
+
+func (d *dbImpl) ReplaceCurrencies(ctx context.Context, currencies []model.Currency) (bool, error) {
+	tx, err := d.database.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("begin replace currencies: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	// Concurrent replacements would insert the same codes after both deleted them
+	var locked bool
+	if err := tx.QueryRowContext(ctx, `SELECT pg_try_advisory_xact_lock(hashtext($1))`, currenciesLock).Scan(&locked); err != nil {
+		return false, fmt.Errorf("lock replace currencies: %w", err)
+	}
+	if !locked {
+		return false, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM currencies`); err != nil {
+		return false, fmt.Errorf("delete currencies: %w", err)
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `
+        INSERT INTO currencies (`+currencyColumns+`)
+        VALUES ($1, $2, $3, $4, $5)
+    `)
+	if err != nil {
+		return false, fmt.Errorf("prepare insert currency: %w", err)
+	}
+	defer func() {
+		_ = stmt.Close()
+	}()
+
+	for _, currency := range currencies {
+		_, err := stmt.ExecContext(ctx, currency.Code, currency.Name, currency.MinorUnits,
+			pq.Array(currency.BaseProviders), pq.Array(currency.TargetProviders))
+		if err != nil {
+			return false, fmt.Errorf("insert currency %s: %w", currency.Code, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("commit replace currencies: %w", err)
+	}
+	return true, nil
+}
+
+func (d *dbImpl) UnsupportedCurrencies(ctx context.Context, codes []string) ([]string, error) {
+	var unsupported []string
+	rows, err := d.database.QueryContext(ctx, `
+        SELECT requested.code
+        FROM unnest($1::text[]) AS requested(code)
+        WHERE EXISTS (SELECT 1 FROM currencies)
+          AND NOT EXISTS (SELECT 1 FROM currencies c WHERE c.code = requested.code)
+    `, pq.Array(codes))
+	if err != nil {
+		return nil, fmt.Errorf("unsupported currencies: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return nil, fmt.Errorf("scan currency code: %w", err)
+		}
+		unsupported = append(unsupported, code)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("unsupported currencies rows: %w", err)
+	}
+
+	return unsupported, nil
+}
@@ -3,18 +3,30 @@ package db
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"strconv"
+	"time"
 
 	"github.com/GlazedCurd/PlataTest/internal/model"
+	"github.com/GlazedCurd/PlataTest/internal/retry"
 	"github.com/lib/pq"
 )
 
 const (
 	pgConflictCode = "23505" // unique_violation
+	// newTaskChannel is the LISTEN/NOTIFY channel InsertTask announces new tasks on.
+	newTaskChannel = "quote_tasks"
+	// listenerPingInterval keeps the LISTEN connection alive and detects broken ones.
+	listenerPingInterval = 90 * time.Second
 )
 
+// taskColumns is the column list every task query returns, in scanTask order.
+const taskColumns = `id, code, idempotency_key, quote, status, created_at, updated_at, claimed_by, lease_expires_at,
+        attempts, last_error, next_attempt_at, provider, conversion_path, contributions, error_code, as_of, rate_date`
+
 var (
 	ErrorConflictWithDifferentBody = errors.New("conflict with different body")
 	ErrorNotFound                  = errors.New("not found")
@@ -24,16 +36,110 @@ type DB interface {
 	Close() error
 	InsertTask(ctx context.Context, task *model.Task) (*model.Task, error)
 	UpdateTask(ctx context.Context, task *model.Task) (*model.Task, error)
+	// RetryTask releases a leased task back to pending, recording task.LastError
+	// and task.ErrorCode and postponing the next claim by delay.
+	RetryTask(ctx context.Context, task *model.Task, delay time.Duration) (*model.Task, error)
 	GetTask(ctx context.Context, code model.Code, taskId model.TaskId) (*model.Task, error)
+	// GetLastSuccessfulTask returns the latest quote of the pair, historical
+	// quotes requested with as_of aside.
 	GetLastSuccessfulTask(ctx context.Context, code model.Code) (*model.Task, error)
-	GetRecentlyTasksToProcess(ctx context.Context) ([]model.Task, error)
+	// GetLatestSuccessfulTasks returns the latest quote of every pair with one
+	// of currencies on either side, historical quotes aside.
+	GetLatestSuccessfulTasks(ctx context.Context, currencies []string) ([]model.Task, error)
+	// GetSuccessfulTaskAsOf returns the latest quote requested for the day or
+	// effective on it.
+	GetSuccessfulTaskAsOf(ctx context.Context, code model.Code, day model.Date) (*model.Task, error)
+	// GetTaskHistory returns up to query.Limit tasks of query.Code with
+	// query.Status ordered by (updated_at, id), starting after query.After.
+	GetTaskHistory(ctx context.Context, query model.HistoryQuery) ([]model.Task, error)
+	// GetCandles returns the OHLC candles of query.Code starting within
+	// [query.From, query.To), ordered by time. Rolled up candles are read from
+	// the rollup table, the most recent ones are aggregated from the quotes.
+	GetCandles(ctx context.Context, query model.CandleQuery) ([]model.Candle, error)
+	// RollupCandles stores the candles of every pair updated since the last
+	// rollup of the interval and reports how many were written. Only one
+	// replica rolls up at a time, the others get 0.
+	RollupCandles(ctx context.Context, interval model.CandleInterval) (int64, error)
+	// ClaimTasks atomically moves up to limit due pending tasks to processing,
+	// leases them to workerId for the given duration and counts the attempt.
+	// Rows already locked by another worker are skipped, so concurrent workers
+	// never get the same task.
+	ClaimTasks(ctx context.Context, workerId string, limit int, lease time.Duration) ([]model.Task, error)
+	// ReleaseExpiredTasks returns processing tasks whose lease has expired back
+	// to pending and reports how many were released.
+	ReleaseExpiredTasks(ctx context.Context) (int64, error)
+	// ReleaseTasks returns processing tasks leased to workerId back to pending
+	// without counting the attempt, e.g. when the worker shuts down.
+	ReleaseTasks(ctx context.Context, workerId string) (int64, error)
+	// SubscribeNewTasks listens for tasks announced by InsertTask. Notifications
+	// are coalesced, so a receive means "there is something to claim" rather than
+	// one task. The channel is closed once ctx is done.
+	SubscribeNewTasks(ctx context.Context) (<-chan struct{}, error)
+	// GetCurrencies returns the supported currencies catalog ordered by code.
+	GetCurrencies(ctx context.Context) ([]model.Currency, error)
+	GetCurrency(ctx context.Context, code string) (*model.Currency, error)
+	// ReplaceCurrencies atomically replaces the whole catalog and reports
+	// whether it did. Only one replica replaces it at a time, the others get false.
+	ReplaceCurrencies(ctx context.Context, currencies []model.Currency) (bool, error)
+	// UnsupportedCurrencies returns the codes no provider quotes. While the
+	// catalog is empty, e.g. before the first sync, every code is supported.
+	UnsupportedCurrencies(ctx context.Context, codes []string) ([]string, error)
 }
 
 type dbImpl struct {
 	database *sql.DB
+	psqlInfo string
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanTask(row rowScanner) (*model.Task, error) {
+	var task model.Task
+	var contributions []byte
+	err := row.Scan(
+		&task.ID,
+		&task.Code,
+		&task.IdempotencyKey,
+		&task.Price,
+		&task.Status,
+		&task.CreatedAt,
+		&task.TaskdAt,
+		&task.ClaimedBy,
+		&task.LeaseExpiresAt,
+		&task.Attempts,
+		&task.LastError,
+		&task.NextAttemptAt,
+		&task.Provider,
+		pq.Array(&task.ConversionPath),
+		&contributions,
+		&task.ErrorCode,
+		&task.AsOf,
+		&task.RateDate,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if contributions != nil {
+		if err := json.Unmarshal(contributions, &task.Contributions); err != nil {
+			return nil, fmt.Errorf("unmarshal contributions: %w", err)
+		}
+	}
+	return &task, nil
 }
 
-func ConnectDB(host, port, user, password, dbname string) (DB, error) {
+// marshalContributions stores no contributions as NULL.
+func marshalContributions(contributions []model.Contribution) ([]byte, error) {
+	if len(contributions) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(contributions)
+}
+
+// ConnectDB opens the database and waits until it answers, pinging it as
+// the policy allows, e.g. while the database container is still starting.
+func ConnectDB(ctx context.Context, host, port, user, password, dbname string, policy retry.Policy) (DB, error) {
 	psqlInfo := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 		host, port, user, password, dbname)
 
@@ -42,35 +148,33 @@ func ConnectDB(host, port, user, password, dbname string) (DB, error) {
 		return nil, fmt.Errorf("database initialization %w", err)
 	}
 
-	err = db.Ping()
+	err = policy.Do(ctx, func(ctx context.Context, attempt int) error {
+		err := db.PingContext(ctx)
+		if err != nil {
+			log.Printf("Pinging database, attempt %d: %s", attempt, err)
+		}
+		return err
+	})
 	if err != nil {
+		_ = db.Close()
 		return nil, fmt.Errorf("database ping %w", err)
 	}
 
 	log.Println("Successfully connected to the database")
-	return &dbImpl{database: db}, nil
+	return &dbImpl{database: db, psqlInfo: psqlInfo}, nil
 }
 
 func (d *dbImpl) Close() error {
 	return d.database.Close()
 }
 
-func (d *dbImpl) GetConflictedTask(ctx context.Context, idempotencyKey string, code model.Code) (*model.Task, error) {
-	var task model.Task
-	err := d.database.QueryRowContext(ctx, `
-        SELECT id, code, idempotency_key, quote, status, created_at, updated_at
+func (d *dbImpl) GetConflictedTask(ctx context.Context, idempotencyKey string, code model.Code, asOf *model.Date) (*model.Task, error) {
+	task, err := scanTask(d.database.QueryRowContext(ctx, `
+        SELECT `+taskColumns+`
         FROM quotes
-        WHERE idempotency_key = $1 AND code = $2
+        WHERE idempotency_key = $1 AND code = $2 AND as_of IS NOT DISTINCT FROM $3
         LIMIT 1
-    `, idempotencyKey, code).Scan(
-		&task.ID,
-		&task.Code,
-		&task.IdempotencyKey,
-		&task.Price,
-		&task.Status,
-		&task.CreatedAt,
-		&task.TaskdAt,
-	)
+    `, idempotencyKey, code, asOf))
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -79,30 +183,29 @@ func (d *dbImpl) GetConflictedTask(ctx context.Context, idempotencyKey string, c
 		return nil, fmt.Errorf("getting conflicted task: %w", err)
 	}
 
-	return &task, nil
+	return task, nil
 }
 
 func (d *dbImpl) InsertTask(ctx context.Context, task *model.Task) (*model.Task, error) {
-	var taskRes model.Task
-	err := d.database.QueryRowContext(ctx, `
-        INSERT INTO quotes (code, idempotency_key) 
-        VALUES ($1, $2) 
-        RETURNING id, code, idempotency_key, quote, status, created_at, updated_at
-    `, task.Code, task.IdempotencyKey).Scan(
-		&taskRes.ID,
-		&taskRes.Code,
-		&taskRes.IdempotencyKey,
-		&taskRes.Price,
-		&taskRes.Status,
-		&taskRes.CreatedAt,
-		&taskRes.TaskdAt,
-	)
+	tx, err := d.database.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin insert task: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	taskRes, err := scanTask(tx.QueryRowContext(ctx, `
+        INSERT INTO quotes (code, idempotency_key, as_of) 
+        VALUES ($1, $2, $3) 
+        RETURNING `+taskColumns+`
+    `, task.Code, task.IdempotencyKey, task.AsOf))
 
 	if err != nil {
 		if pqErr, ok := err.(*pq.Error); ok {
 			switch pqErr.Code {
 			case pgConflictCode:
-				taskResP, err := d.GetConflictedTask(ctx, task.IdempotencyKey, task.Code)
+				taskResP, err := d.GetConflictedTask(ctx, task.IdempotencyKey, task.Code, task.AsOf)
 				if err != nil {
 					return nil, fmt.Errorf("get conflicted task: %w", err)
 				}
@@ -117,24 +220,25 @@ func (d *dbImpl) InsertTask(ctx context.Context, task *model.Task) (*model.Task,
 		return nil, fmt.Errorf("insert and scan task: %w", err)
 	}
 
-	return &taskRes, nil
+	// Delivered to listeners on commit, so workers never wake up before the row is visible
+	_, err = tx.ExecContext(ctx, `SELECT pg_notify($1, $2)`, newTaskChannel, strconv.FormatUint(taskRes.ID, 10))
+	if err != nil {
+		return nil, fmt.Errorf("notify new task: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit insert task: %w", err)
+	}
+
+	return taskRes, nil
 }
 
 func (d *dbImpl) GetTask(ctx context.Context, code model.Code, taskId model.TaskId) (*model.Task, error) {
-	var task model.Task
-	err := d.database.QueryRowContext(ctx, `
-        SELECT id, code, idempotency_key, quote, status, created_at, updated_at
+	task, err := scanTask(d.database.QueryRowContext(ctx, `
+        SELECT `+taskColumns+`
         FROM quotes
         WHERE id = $1 AND code = $2
-    `, taskId, code).Scan(
-		&task.ID,
-		&task.Code,
-		&task.IdempotencyKey,
-		&task.Price,
-		&task.Status,
-		&task.CreatedAt,
-		&task.TaskdAt,
-	)
+    `, taskId, code))
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -143,27 +247,34 @@ func (d *dbImpl) GetTask(ctx context.Context, code model.Code, taskId model.Task
 		return nil, fmt.Errorf("get task: %w", err)
 	}
 
-	return &task, nil
+	return task, nil
 }
 
+// UpdateTask stores the processing result and releases the lease. The update
+// only applies while the task is still leased to task.ClaimedBy, so a worker
+// whose lease was lost gets ErrorNotFound instead of overwriting the row.
 func (d *dbImpl) UpdateTask(ctx context.Context, task *model.Task) (*model.Task, error) {
-	var updatedRes model.Task
-	err := d.database.QueryRowContext(ctx, `
+	contributions, err := marshalContributions(task.Contributions)
+	if err != nil {
+		return nil, fmt.Errorf("marshal contributions: %w", err)
+	}
+	updatedRes, err := scanTask(d.database.QueryRowContext(ctx, `
         UPDATE quotes 
         SET status = $1,
             quote = $2,
+            last_error = $3,
+            provider = $4,
+            conversion_path = $5,
+            contributions = $6,
+            error_code = $7,
+            rate_date = $8,
+            claimed_by = NULL,
+            lease_expires_at = NULL,
+            next_attempt_at = NULL,
             updated_at = CURRENT_TIMESTAMP
-        WHERE id = $3
-        RETURNING id, code, idempotency_key, quote, status, created_at, updated_at
-    `, task.Status, task.Price, task.ID).Scan(
-		&updatedRes.ID,
-		&updatedRes.Code,
-		&updatedRes.IdempotencyKey,
-		&updatedRes.Price,
-		&updatedRes.Status,
-		&updatedRes.CreatedAt,
-		&updatedRes.TaskdAt,
-	)
+        WHERE id = $9 AND status = 'processing' AND claimed_by = $10
+        RETURNING `+taskColumns+`
+    `, task.Status, task.Price, task.LastError, task.Provider, pq.Array(task.ConversionPath), contributions, task.ErrorCode, task.RateDate, task.ID, task.ClaimedBy))
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -172,26 +283,41 @@ func (d *dbImpl) UpdateTask(ctx context.Context, task *model.Task) (*model.Task,
 		return nil, fmt.Errorf("task quote: %w", err)
 	}
 
-	return &updatedRes, nil
+	return updatedRes, nil
+}
+
+func (d *dbImpl) RetryTask(ctx context.Context, task *model.Task, delay time.Duration) (*model.Task, error) {
+	retriedRes, err := scanTask(d.database.QueryRowContext(ctx, `
+        UPDATE quotes
+        SET status = 'pending',
+            last_error = $1,
+            error_code = $2,
+            claimed_by = NULL,
+            lease_expires_at = NULL,
+            next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $3),
+            updated_at = CURRENT_TIMESTAMP
+        WHERE id = $4 AND status = 'processing' AND claimed_by = $5
+        RETURNING `+taskColumns+`
+    `, task.LastError, task.ErrorCode, delay.Seconds(), task.ID, task.ClaimedBy))
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrorNotFound
+		}
+		return nil, fmt.Errorf("retry task: %w", err)
+	}
+
+	return retriedRes, nil
 }
 
 func (d *dbImpl) GetLastSuccessfulTask(ctx context.Context, code model.Code) (*model.Task, error) {
-	var task model.Task
-	err := d.database.QueryRowContext(ctx, `
-        SELECT id, code, idempotency_key, quote, status, created_at, updated_at
+	task, err := scanTask(d.database.QueryRowContext(ctx, `
+        SELECT `+taskColumns+`
         FROM quotes
-        WHERE code = $1 AND status = 'success'
+        WHERE code = $1 AND status = 'success' AND as_of IS NULL
         ORDER BY updated_at DESC
         LIMIT 1
-    `, code).Scan(
-		&task.ID,
-		&task.Code,
-		&task.IdempotencyKey,
-		&task.Price,
-		&task.Status,
-		&task.CreatedAt,
-		&task.TaskdAt,
-	)
+    `, code))
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -200,37 +326,211 @@ func (d *dbImpl) GetLastSuccessfulTask(ctx context.Context, code model.Code) (*m
 		return nil, fmt.Errorf("get last successful task: %w", err)
 	}
 
-	return &task, nil
+	return task, nil
 }
 
-func (d *dbImpl) GetRecentlyTasksToProcess(ctx context.Context) ([]model.Task, error) {
+func (d *dbImpl) GetLatestSuccessfulTasks(ctx context.Context, currencies []string) ([]model.Task, error) {
 	var tasks []model.Task
 	rows, err := d.database.QueryContext(ctx, `
-        SELECT id, code, idempotency_key, quote, status, created_at, updated_at	
+        SELECT DISTINCT ON (code) `+taskColumns+`
         FROM quotes
-        WHERE status = 'pending'
-        ORDER BY created_at DESC
-    `)
+        WHERE status = 'success' AND as_of IS NULL
+            AND (split_part(code, '_', 1) = ANY($1) OR split_part(code, '_', 2) = ANY($1))
+        ORDER BY code, updated_at DESC
+    `, pq.Array(currencies))
 	if err != nil {
-		return nil, fmt.Errorf("get recently requested tasks: %w", err)
+		return nil, fmt.Errorf("get latest successful tasks: %w", err)
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var task model.Task
-		if err := rows.Scan(
-			&task.ID,
-			&task.Code,
-			&task.IdempotencyKey,
-			&task.Price,
-			&task.Status,
-			&task.CreatedAt,
-			&task.TaskdAt,
-		); err != nil {
+		task, err := scanTask(rows)
+		if err != nil {
 			return nil, fmt.Errorf("scan task: %w", err)
 		}
-		tasks = append(tasks, task)
+		tasks = append(tasks, *task)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("get latest successful tasks rows: %w", err)
 	}
 
 	return tasks, nil
 }
+
+func (d *dbImpl) GetSuccessfulTaskAsOf(ctx context.Context, code model.Code, day model.Date) (*model.Task, error) {
+	task, err := scanTask(d.database.QueryRowContext(ctx, `
+        SELECT `+taskColumns+`
+        FROM quotes
+        WHERE code = $1 AND status = 'success' AND (as_of = $2 OR rate_date = $2)
+        ORDER BY updated_at DESC
+        LIMIT 1
+    `, code, day))
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrorNotFound
+		}
+		return nil, fmt.Errorf("get successful task as of %s: %w", day, err)
+	}
+
+	return task, nil
+}
+
+func (d *dbImpl) GetTaskHistory(ctx context.Context, query model.HistoryQuery) ([]model.Task, error) {
+	var from, to, afterUpdatedAt sql.NullTime
+	var afterId sql.NullInt64
+	if !query.From.IsZero() {
+		from = sql.NullTime{Time: query.From.UTC(), Valid: true}
+	}
+	if !query.To.IsZero() {
+		to = sql.NullTime{Time: query.To.UTC(), Valid: true}
+	}
+	if query.After != nil {
+		afterUpdatedAt = sql.NullTime{Time: query.After.UpdatedAt, Valid: true}
+		afterId = sql.NullInt64{Int64: int64(query.After.ID), Valid: true}
+	}
+
+	var tasks []model.Task
+	rows, err := d.database.QueryContext(ctx, `
+        SELECT `+taskColumns+`
+        FROM quotes
+        WHERE code = $1 AND status = $2
+            AND ($3::timestamp IS NULL OR updated_at >= $3)
+            AND ($4::timestamp IS NULL OR updated_at < $4)
+            AND ($5::timestamp IS NULL OR (updated_at, id) > ($5, $6))
+        ORDER BY updated_at, id
+        LIMIT $7
+    `, query.Code, query.Status, from, to, afterUpdatedAt, afterId, query.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("get task history: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan task: %w", err)
+		}
+		tasks = append(tasks, *task)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("get task history rows: %w", err)
+	}
+
+	return tasks, nil
+}
+
+func (d *dbImpl) ClaimTasks(ctx context.Context, workerId string, limit int, lease time.Duration) ([]model.Task, error) {
+	var tasks []model.Task
+	rows, err := d.database.QueryContext(ctx, `
+        UPDATE quotes
+        SET status = 'processing',
+            claimed_by = $1,
+            lease_expires_at = CURRENT_TIMESTAMP + make_interval(secs => $2),
+            attempts = attempts + 1
+        WHERE id IN (
+            SELECT id
+            FROM quotes
+            WHERE status = 'pending'
+                AND (next_attempt_at IS NULL OR next_attempt_at <= CURRENT_TIMESTAMP)
+            ORDER BY created_at
+            LIMIT $3
+            FOR UPDATE SKIP LOCKED
+        )
+        RETURNING `+taskColumns+`
+    `, workerId, lease.Seconds(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("claim tasks: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan task: %w", err)
+		}
+		tasks = append(tasks, *task)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("claim tasks rows: %w", err)
+	}
+
+	return tasks, nil
+}
+
+func (d *dbImpl) ReleaseExpiredTasks(ctx context.Context) (int64, error) {
+	res, err := d.database.ExecContext(ctx, `
+        UPDATE quotes
+        SET status = 'pending',
+            claimed_by = NULL,
+            lease_expires_at = NULL
+        WHERE status = 'processing' AND lease_expires_at < CURRENT_TIMESTAMP
+    `)
+	if err != nil {
+		return 0, fmt.Errorf("release expired tasks: %w", err)
+	}
+	released, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("release expired tasks rows affected: %w", err)
+	}
+	return released, nil
+}
+
+func (d *dbImpl) ReleaseTasks(ctx context.Context, workerId string) (int64, error) {
+	res, err := d.database.ExecContext(ctx, `
+        UPDATE quotes
+        SET status = 'pending',
+            claimed_by = NULL,
+            lease_expires_at = NULL,
+            attempts = GREATEST(attempts - 1, 0)
+        WHERE status = 'processing' AND claimed_by = $1
+    `, workerId)
+	if err != nil {
+		return 0, fmt.Errorf("release tasks: %w", err)
+	}
+	released, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("release tasks rows affected: %w", err)
+	}
+	return released, nil
+}
+
+func (d *dbImpl) SubscribeNewTasks(ctx context.Context) (<-chan struct{}, error) {
+	listener := pq.NewListener(d.psqlInfo, 10*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Task listener event %d: %s", ev, err)
+		}
+	})
+	if err := listener.Listen(newTaskChannel); err != nil {
+		_ = listener.Close()
+		return nil, fmt.Errorf("listen %s: %w", newTaskChannel, err)
+	}
+
+	notifications := make(chan struct{}, 1)
+	go func() {
+		defer close(notifications)
+		defer func() {
+			_ = listener.Close()
+		}()
+		ping := time.NewTicker(listenerPingInterval)
+		defer ping.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			// A nil notification follows a reconnect: some may have been lost, so wake up anyway
+			case <-listener.Notify:
+				select {
+				case notifications <- struct{}{}:
+				default:
+				}
+			case <-ping.C:
+				go func() {
+					_ = listener.Ping()
+				}()
+			}
+		}
+	}()
+
+	return notifications, nil
+}
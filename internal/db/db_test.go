@@ -0,0 +1,377 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GlazedCurd/PlataTest/internal/model"
+	"github.com/go-playground/assert/v2"
+	"github.com/shopspring/decimal"
+)
+
+// newTestDB migrates a fresh schema in the database from TEST_DATABASE_DSN
+// (key=value form) and drops it once the test is over. Tests are skipped
+// when the variable isn't set.
+func newTestDB(t *testing.T) *dbImpl {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`); err != nil {
+			t.Error(err)
+		}
+	})
+
+	psqlInfo := dsn + " search_path=" + schema
+	database, err := sql.Open("postgres", psqlInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	migrate(t, database)
+	return &dbImpl{database: database, psqlInfo: psqlInfo}
+}
+
+// migrate applies the up migrations in version order, each file in its own
+// implicit transaction the way migrate does.
+func migrate(t *testing.T, database *sql.DB) {
+	t.Helper()
+	files, err := filepath.Glob("../../migrations/*.up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.SortFunc(files, func(a, b string) int {
+		return migrationVersion(a) - migrationVersion(b)
+	})
+	for _, file := range files {
+		query, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := database.Exec(string(query)); err != nil {
+			t.Fatalf("migration %s: %v", filepath.Base(file), err)
+		}
+	}
+}
+
+func migrationVersion(file string) int {
+	version, _ := strconv.Atoi(strings.SplitN(filepath.Base(file), "_", 2)[0])
+	return version
+}
+
+func insertTasks(t *testing.T, d *dbImpl, code model.Code, count int) []model.TaskId {
+	t.Helper()
+	var ids []model.TaskId
+	for i := range count {
+		task, err := d.InsertTask(context.Background(), &model.Task{Code: code, IdempotencyKey: fmt.Sprintf("key-%d", i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, task.ID)
+	}
+	return ids
+}
+
+func TestClaimTasksConcurrently(t *testing.T) {
+	d := newTestDB(t)
+	ctx := context.Background()
+	const numTasks = 50
+	insertTasks(t, d, "EUR_USD", numTasks)
+
+	var mu sync.Mutex
+	claimedBy := make(map[model.TaskId]string)
+	var wg sync.WaitGroup
+	for i := range 5 {
+		workerId := fmt.Sprintf("worker-%d", i)
+		wg.Go(func() {
+			for {
+				tasks, err := d.ClaimTasks(ctx, workerId, 3, time.Minute)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if len(tasks) == 0 {
+					return
+				}
+				mu.Lock()
+				for _, task := range tasks {
+					if other, ok := claimedBy[task.ID]; ok {
+						t.Errorf("task %d claimed by both %s and %s", task.ID, other, workerId)
+					}
+					claimedBy[task.ID] = workerId
+					if task.Status != model.STATUS_PROCESSING || *task.ClaimedBy != workerId {
+						t.Errorf("task %d claimed as %s by %s", task.ID, task.Status, *task.ClaimedBy)
+					}
+				}
+				mu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+
+	assert.Equal(t, len(claimedBy), numTasks)
+}
+
+func TestUpdateTaskLeaseLost(t *testing.T) {
+	d := newTestDB(t)
+	ctx := context.Background()
+	ids := insertTasks(t, d, "EUR_USD", 1)
+
+	claimed, err := d.ClaimTasks(ctx, "worker-1", 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(claimed), 1)
+	// The lease is handed over to another worker behind worker-1's back
+	if _, err := d.database.ExecContext(ctx, `UPDATE quotes SET claimed_by = 'worker-2' WHERE id = $1`, ids[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	task := claimed[0]
+	price := decimal.RequireFromString("1.17")
+	task.Price = &price
+	task.Status = model.STATUS_SUCCESS
+	_, err = d.UpdateTask(ctx, &task)
+	assert.Equal(t, err, ErrorNotFound)
+
+	owner := "worker-2"
+	task.ClaimedBy = &owner
+	updated, err := d.UpdateTask(ctx, &task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, updated.Status, model.STATUS_SUCCESS)
+	assert.Equal(t, updated.ClaimedBy, (*string)(nil))
+	assert.Equal(t, updated.LeaseExpiresAt, (*time.Time)(nil))
+
+	// A finished task isn't leased to anyone anymore
+	_, err = d.UpdateTask(ctx, &task)
+	assert.Equal(t, err, ErrorNotFound)
+}
+
+func TestReleaseExpiredTasks(t *testing.T) {
+	d := newTestDB(t)
+	ctx := context.Background()
+	ids := insertTasks(t, d, "EUR_USD", 2)
+
+	claimed, err := d.ClaimTasks(ctx, "worker-1", 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(claimed), 2)
+	// Only the first lease has run out
+	if _, err := d.database.ExecContext(ctx, `
+        UPDATE quotes SET lease_expires_at = CURRENT_TIMESTAMP - interval '1 second' WHERE id = $1
+    `, ids[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	released, err := d.ReleaseExpiredTasks(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, released, int64(1))
+
+	expired, err := d.GetTask(ctx, "EUR_USD", ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, expired.Status, model.STATUS_PENDING)
+	assert.Equal(t, expired.ClaimedBy, (*string)(nil))
+	assert.Equal(t, expired.LeaseExpiresAt, (*time.Time)(nil))
+	// The lost attempt still counts, so a task crashing its workers runs out of them
+	assert.Equal(t, expired.Attempts, 1)
+	leased, err := d.GetTask(ctx, "EUR_USD", ids[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, leased.Status, model.STATUS_PROCESSING)
+
+	// The worker that lost the lease can't finish the task anymore
+	i := slices.IndexFunc(claimed, func(task model.Task) bool { return task.ID == ids[0] })
+	stale := claimed[i]
+	stale.Status = model.STATUS_SUCCESS
+	_, err = d.UpdateTask(ctx, &stale)
+	assert.Equal(t, err, ErrorNotFound)
+
+	reclaimed, err := d.ClaimTasks(ctx, "worker-2", 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(reclaimed), 1)
+	assert.Equal(t, reclaimed[0].ID, ids[0])
+	assert.Equal(t, reclaimed[0].Attempts, 2)
+}
+
+func TestRetryTask(t *testing.T) {
+	d := newTestDB(t)
+	ctx := context.Background()
+	ids := insertTasks(t, d, "EUR_USD", 1)
+
+	claimed, err := d.ClaimTasks(ctx, "worker-1", 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(claimed), 1)
+	task := claimed[0]
+	assert.Equal(t, task.Attempts, 1)
+
+	lastError := "connection reset"
+	task.LastError = &lastError
+	retried, err := d.RetryTask(ctx, &task, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, retried.Status, model.STATUS_PENDING)
+	assert.Equal(t, *retried.LastError, lastError)
+	assert.Equal(t, retried.ClaimedBy, (*string)(nil))
+
+	// The task isn't due before its next attempt
+	notDue, err := d.ClaimTasks(ctx, "worker-2", 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(notDue), 0)
+
+	// Nor can it be retried again by the worker that gave it back
+	_, err = d.RetryTask(ctx, &task, 0)
+	assert.Equal(t, err, ErrorNotFound)
+
+	if _, err := d.database.ExecContext(ctx, `
+        UPDATE quotes SET next_attempt_at = CURRENT_TIMESTAMP - interval '1 second' WHERE id = $1
+    `, ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	due, err := d.ClaimTasks(ctx, "worker-2", 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(due), 1)
+	assert.Equal(t, due[0].Attempts, 2)
+}
+
+func TestReleaseTasks(t *testing.T) {
+	d := newTestDB(t)
+	ctx := context.Background()
+	insertTasks(t, d, "EUR_USD", 2)
+
+	mine, err := d.ClaimTasks(ctx, "worker-1", 1, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	theirs, err := d.ClaimTasks(ctx, "worker-2", 1, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(mine), 1)
+	assert.Equal(t, len(theirs), 1)
+
+	released, err := d.ReleaseTasks(ctx, "worker-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, released, int64(1))
+
+	task, err := d.GetTask(ctx, "EUR_USD", mine[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, task.Status, model.STATUS_PENDING)
+	assert.Equal(t, task.ClaimedBy, (*string)(nil))
+	// The interrupted attempt isn't the task's fault, so it isn't counted
+	assert.Equal(t, task.Attempts, 0)
+	task, err = d.GetTask(ctx, "EUR_USD", theirs[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, task.Status, model.STATUS_PROCESSING)
+	assert.Equal(t, *task.ClaimedBy, "worker-2")
+}
+
+func TestSubscribeNewTasks(t *testing.T) {
+	d := newTestDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	newTasks, err := d.SubscribeNewTasks(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	insertTasks(t, d, "EUR_USD", 1)
+	select {
+	case <-newTasks:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification about the new task")
+	}
+
+	cancel()
+	select {
+	case _, ok := <-newTasks:
+		assert.Equal(t, ok, false)
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscription outlived its context")
+	}
+}
+
+func insertQuote(t *testing.T, d *dbImpl, code model.Code, quote string, updatedAt time.Time) {
+	t.Helper()
+	if _, err := d.database.Exec(`
+        INSERT INTO quotes (code, idempotency_key, status, quote, updated_at)
+        VALUES ($1, $2, 'success', $3, $4)
+    `, code, fmt.Sprintf("quote-%d", updatedAt.UnixNano()), quote, updatedAt); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetCandlesAfterRollup(t *testing.T) {
+	d := newTestDB(t)
+	ctx := context.Background()
+	hour, _ := model.ParseCandleInterval("1h")
+	day := time.Date(2025, 8, 17, 0, 0, 0, 0, time.UTC)
+	insertQuote(t, d, "EUR_USD", "1.10", day.Add(10*time.Hour+5*time.Minute))
+	insertQuote(t, d, "EUR_USD", "1.12", day.Add(10*time.Hour+20*time.Minute))
+	insertQuote(t, d, "EUR_USD", "1.09", day.Add(10*time.Hour+40*time.Minute))
+	insertQuote(t, d, "USD_JPY", "147.5", day.Add(12*time.Hour+10*time.Minute))
+
+	rolledUp, err := d.RollupCandles(ctx, hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, rolledUp, int64(2))
+	// Committed after the rollup, while other pairs have moved on
+	insertQuote(t, d, "EUR_USD", "1.11", day.Add(10*time.Hour+50*time.Minute))
+
+	candles, err := d.GetCandles(ctx, model.CandleQuery{Code: "EUR_USD", Interval: hour, From: day, To: day.Add(24 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(candles), 1)
+	candle := candles[0]
+	assert.Equal(t, candle.Time, day.Add(10*time.Hour))
+	assert.Equal(t, candle.Open.String(), "1.1")
+	assert.Equal(t, candle.High.String(), "1.12")
+	assert.Equal(t, candle.Low.String(), "1.09")
+	assert.Equal(t, candle.Close.String(), "1.11")
+	assert.Equal(t, candle.Count, 4)
+}
@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/GlazedCurd/PlataTest/internal/model"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultCandleInterval = "1h"
+	// defaultCandles is how many candles back from to are returned without from.
+	defaultCandles = 100
+	maxCandles     = 10000
+)
+
+// candleQuery parses the query string of the OHLC endpoint.
+func candleQuery(c *gin.Context, pair model.Code, now time.Time) (model.CandleQuery, error) {
+	query := model.CandleQuery{Code: pair, To: now}
+	var ok bool
+	name := c.DefaultQuery("interval", defaultCandleInterval)
+	if query.Interval, ok = model.ParseCandleInterval(name); !ok {
+		return query, fmt.Errorf("invalid interval %q", name)
+	}
+	var err error
+	if to := c.Query("to"); to != "" {
+		if query.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return query, fmt.Errorf("invalid to: %w", err)
+		}
+	}
+	query.From = query.To.Add(-defaultCandles * query.Interval.Duration())
+	if from := c.Query("from"); from != "" {
+		if query.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return query, fmt.Errorf("invalid from: %w", err)
+		}
+	}
+	if !query.From.Before(query.To) {
+		return query, errors.New("from must be before to")
+	}
+	if query.To.Sub(query.From) > maxCandles*query.Interval.Duration() {
+		return query, fmt.Errorf("range exceeds %d candles, use a wider interval", maxCandles)
+	}
+	return query, nil
+}
+
+func (h *Handler) GetCandles(c *gin.Context) {
+	pair, ok := h.pairParam(c)
+	if !ok {
+		return
+	}
+	query, err := candleQuery(c, pair, time.Now())
+	if err != nil {
+		h.zapLogger.Info("Invalid candle query", zap.String("pair", pair), zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	h.zapLogger.Info("Candles requested", zap.String("pair", pair), zap.String("interval", query.Interval.Name),
+		zap.Time("from", query.From), zap.Time("to", query.To))
+
+	candles, err := h.db.GetCandles(c.Request.Context(), query)
+	if err != nil {
+		h.zapLogger.Error("get candles", zap.String("pair", pair), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get candles"})
+		return
+	}
+	if candles == nil {
+		candles = []model.Candle{}
+	}
+	h.renderPrices(c, http.StatusOK, model.Candles{Code: pair, Interval: query.Interval.Name, Candles: candles})
+}
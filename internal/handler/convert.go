@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GlazedCurd/PlataTest/internal/currency"
+	"github.com/GlazedCurd/PlataTest/internal/db"
+	"github.com/GlazedCurd/PlataTest/internal/model"
+	"github.com/GlazedCurd/PlataTest/internal/rategraph"
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+)
+
+// Amounts are bounded before any arithmetic: a decimal like 1e50000000 is
+// cheap to parse but not to multiply out and print.
+const (
+	maxAmountDigits   = 40
+	maxAmountExponent = 30
+)
+
+// storedRates returns the quotes to convert along the pair: the latest quote
+// of the pair itself or, failing that, the latest quotes of every pair sharing
+// a currency with it, to use backwards or triangulate through. Quotes updated
+// before since are skipped.
+func (h *Handler) storedRates(ctx context.Context, pair currency.Pair, since time.Time) ([]model.Task, error) {
+	task, err := h.db.GetLastSuccessfulTask(ctx, pair.String())
+	if err == nil && !task.TaskdAt.Before(since) {
+		return []model.Task{*task}, nil
+	}
+	if err != nil && !errors.Is(err, db.ErrorNotFound) {
+		return nil, err
+	}
+	tasks, err := h.db.GetLatestSuccessfulTasks(ctx, []string{pair.Base, pair.Quote})
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(tasks, func(task model.Task) bool {
+		return task.TaskdAt.Before(since)
+	}), nil
+}
+
+func (h *Handler) Convert(c *gin.Context) {
+	pair, err := currency.ParsePair(c.Query("from") + "_" + c.Query("to"))
+	if err != nil {
+		h.zapLogger.Info("Invalid conversion currencies", zap.String("from", c.Query("from")), zap.String("to", c.Query("to")), zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	amount, err := decimal.NewFromString(c.Query("amount"))
+	if err != nil {
+		h.zapLogger.Info("Invalid conversion amount", zap.String("amount", c.Query("amount")), zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid amount"})
+		return
+	}
+	if amount.NumDigits() > maxAmountDigits || amount.Exponent() > maxAmountExponent || amount.Exponent() < -maxAmountExponent {
+		h.zapLogger.Info("Conversion amount out of range", zap.String("amount", c.Query("amount")))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Amount out of range"})
+		return
+	}
+	rounding, err := currency.ParseRoundingMode(c.DefaultQuery("rounding", string(currency.RoundHalfEven)))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	h.zapLogger.Info("Conversion requested", zap.Stringer("amount", amount), zap.String("pair", pair.String()))
+
+	var since time.Time
+	if h.cfg.ConvertMaxQuoteAge > 0 {
+		since = time.Now().Add(-h.cfg.ConvertMaxQuoteAge)
+	}
+	tasks, err := h.storedRates(c.Request.Context(), pair, since)
+	if err != nil {
+		h.zapLogger.Error("get stored rates", zap.String("pair", pair.String()), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get stored rates"})
+		return
+	}
+	graph := rategraph.New()
+	byId := make(map[string]model.Task, len(tasks))
+	for _, task := range tasks {
+		base, quote, ok := strings.Cut(task.Code, "_")
+		if !ok || task.Price == nil {
+			continue
+		}
+		id := strconv.FormatUint(task.ID, 10)
+		graph.Add(base, quote, *task.Price, id)
+		byId[id] = task
+	}
+	conversion, ok := graph.Convert(pair.Base, pair.Quote)
+	if !ok {
+		h.zapLogger.Info("No stored rate to convert", zap.String("pair", pair.String()))
+		c.JSON(http.StatusNotFound, gin.H{"error": "No stored rate for the pair, request a quote first"})
+		return
+	}
+
+	res := model.Conversion{
+		From:     pair.Base,
+		To:       pair.Quote,
+		Amount:   amount,
+		Rate:     conversion.Rate,
+		Rounding: string(rounding),
+		Path:     conversion.Path,
+	}
+	for _, hop := range conversion.Hops {
+		task := byId[hop.Source]
+		res.Quotes = append(res.Quotes, model.ConversionQuote{
+			TaskID:    task.ID,
+			Code:      task.Code,
+			Price:     *task.Price,
+			Inverse:   hop.Inverse,
+			UpdatedAt: task.TaskdAt,
+		})
+		if res.Timestamp.IsZero() || task.TaskdAt.Before(res.Timestamp) {
+			res.Timestamp = task.TaskdAt
+		}
+	}
+	target, _ := currency.Lookup(pair.Quote)
+	res.Result = target.RoundAmount(amount.Mul(conversion.Rate), rounding, model.PriceScale)
+	h.renderPrices(c, http.StatusOK, res)
+}
@@ -4,33 +4,87 @@ import (
 	"errors"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/GlazedCurd/PlataTest/internal/currency"
 	"github.com/GlazedCurd/PlataTest/internal/db"
 	"github.com/GlazedCurd/PlataTest/internal/model"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 )
 
+// Config tunes the handlers. The zero value disables every limit.
+type Config struct {
+	// ConvertMaxQuoteAge is how old a stored quote may be to convert with.
+	ConvertMaxQuoteAge time.Duration
+}
+
 type Handler struct {
 	db        db.DB
+	cfg       Config
 	zapLogger *zap.Logger
 }
 
-func SetupHandlers(r *gin.Engine, db db.DB, zapLogger *zap.Logger) {
-	h := &Handler{db: db, zapLogger: zapLogger}
+func SetupHandlers(r *gin.Engine, db db.DB, cfg Config, zapLogger *zap.Logger) {
+	h := &Handler{db: db, cfg: cfg, zapLogger: zapLogger}
+	// Match on the escaped path, so that pairs like EUR%2FUSD stay one segment
+	r.UseRawPath = true
+	r.UnescapePathValues = true
 	// Set up routes
-	r.GET("/quotes/:PAIR", h.GetLatest)
-	r.POST("/quotes/:PAIR/task", h.RequestTask)
-	r.GET("/quotes/:PAIR/task/:TASK_ID", h.GetTask)
+	r.GET("/quotes/:PAIR", PriceFormat(), h.GetLatest)
+	r.POST("/quotes/:PAIR/task", PriceFormat(), h.RequestTask)
+	r.GET("/quotes/:PAIR/task/:TASK_ID", PriceFormat(), h.GetTask)
+	r.GET("/quotes/:PAIR/history", PriceFormat(), h.GetHistory)
+	r.GET("/quotes/:PAIR/ohlc", PriceFormat(), h.GetCandles)
+	r.GET("/convert", PriceFormat(), h.Convert)
+	r.GET("/currencies", h.GetCurrencies)
+	r.GET("/currencies/:CODE", h.GetCurrency)
+}
+
+// MaxBodySize rejects request bodies larger than limit bytes.
+func MaxBodySize(limit int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		c.Next()
+	}
+}
+
+// pairParam parses the PAIR path parameter into its canonical form. Invalid
+// pairs are answered with 400.
+func (h *Handler) pairParam(c *gin.Context) (model.Code, bool) {
+	pair, err := currency.ParsePair(c.Param("PAIR"))
+	if err != nil {
+		h.zapLogger.Info("Invalid pair", zap.String("pair", c.Param("PAIR")), zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return "", false
+	}
+	return model.Code(pair.String()), true
 }
 
 func (h *Handler) GetLatest(c *gin.Context) {
-	h.zapLogger.Info("Last task requested", zap.String("pair", c.Param("PAIR")))
-	pair := c.Param("PAIR")
-	lastTask, err := h.db.GetLastSuccessfulTask(c.Request.Context(), model.Code(pair))
+	pair, ok := h.pairParam(c)
+	if !ok {
+		return
+	}
+	var lastTask *model.Task
+	var err error
+	if asOf := c.Query("as_of"); asOf != "" {
+		day, parseErr := model.ParseDate(asOf)
+		if parseErr != nil {
+			h.zapLogger.Info("Invalid as_of", zap.String("pair", pair), zap.String("as_of", asOf), zap.Error(parseErr))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid as_of, expected YYYY-MM-DD"})
+			return
+		}
+		h.zapLogger.Info("Task as of day requested", zap.String("pair", pair), zap.Stringer("as_of", day))
+		lastTask, err = h.db.GetSuccessfulTaskAsOf(c.Request.Context(), pair, day)
+	} else {
+		h.zapLogger.Info("Last task requested", zap.String("pair", pair))
+		lastTask, err = h.db.GetLastSuccessfulTask(c.Request.Context(), pair)
+	}
 	if err != nil {
 		if errors.Is(err, db.ErrorNotFound) {
-			h.zapLogger.Error("Task not found", zap.String("pair", c.Param("PAIR")))
+			h.zapLogger.Error("Task not found", zap.String("pair", pair))
 			c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
 			return
 		}
@@ -38,49 +92,112 @@ func (h *Handler) GetLatest(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get last successful task"})
 		return
 	}
-	c.JSON(http.StatusOK, lastTask)
+	h.renderPrices(c, http.StatusOK, lastTask)
 }
 
 func (h *Handler) RequestTask(c *gin.Context) {
+	pair, ok := h.pairParam(c)
+	if !ok {
+		return
+	}
 	var task model.Task
 	if err := c.ShouldBindJSON(&task); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body too large"})
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	task.Code = model.Code(c.Param("PAIR"))
-	h.zapLogger.Info("New task requested", zap.String("pair", c.Param("PAIR")), zap.String("idempotency_key", task.IdempotencyKey))
+	if task.AsOf != nil && task.AsOf.After(time.Now().UTC()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "as_of must not be in the future"})
+		return
+	}
+	base, quote, _ := strings.Cut(pair, "_")
+	unsupported, err := h.db.UnsupportedCurrencies(c.Request.Context(), []string{base, quote})
+	if err != nil {
+		h.zapLogger.Error("unsupported currencies", zap.String("pair", pair), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check supported currencies"})
+		return
+	}
+	if len(unsupported) > 0 {
+		h.zapLogger.Info("Pair not supported", zap.String("pair", pair), zap.Strings("currencies", unsupported))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Currency not supported by any provider: " + strings.Join(unsupported, ", ")})
+		return
+	}
+
+	task.Code = pair
+	h.zapLogger.Info("New task requested", zap.String("pair", pair), zap.String("idempotency_key", task.IdempotencyKey))
 	insertedTask, err := h.db.InsertTask(c.Request.Context(), &task)
 	if err != nil {
 		if errors.Is(err, db.ErrorConflictWithDifferentBody) {
-			h.zapLogger.Error("Conflict with different body", zap.String("pair", c.Param("PAIR")), zap.String("idempotency_key", task.IdempotencyKey))
+			h.zapLogger.Error("Conflict with different body", zap.String("pair", pair), zap.String("idempotency_key", task.IdempotencyKey))
 			c.JSON(http.StatusConflict, gin.H{"error": "Conflict with different body"})
 			return
 		}
-		h.zapLogger.Error("insert task", zap.String("pair", c.Param("PAIR")), zap.String("idempotency_key", task.IdempotencyKey), zap.Error(err))
+		h.zapLogger.Error("insert task", zap.String("pair", pair), zap.String("idempotency_key", task.IdempotencyKey), zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert task"})
 		return
 	}
-	c.JSON(http.StatusOK, insertedTask)
+	h.renderPrices(c, http.StatusOK, insertedTask)
 }
 
 func (h *Handler) GetTask(c *gin.Context) {
+	pair, ok := h.pairParam(c)
+	if !ok {
+		return
+	}
 	taskId, err := strconv.Atoi(c.Param("TASK_ID"))
 	if err != nil {
-		h.zapLogger.Error("Invalid task ID", zap.String("pair", c.Param("PAIR")), zap.Error(err))
+		h.zapLogger.Error("Invalid task ID", zap.String("pair", pair), zap.Error(err))
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid task ID"})
 		return
 	}
-	h.zapLogger.Info("Task requested", zap.String("pair", c.Param("PAIR")), zap.Int("task_id", int(taskId)))
-	task, err := h.db.GetTask(c.Request.Context(), model.Code(c.Param("PAIR")), model.TaskId(taskId))
+	h.zapLogger.Info("Task requested", zap.String("pair", pair), zap.Int("task_id", int(taskId)))
+	task, err := h.db.GetTask(c.Request.Context(), pair, model.TaskId(taskId))
 	if err != nil {
 		if errors.Is(err, db.ErrorNotFound) {
-			h.zapLogger.Error("Task not found", zap.String("pair", c.Param("PAIR")), zap.Int("task_id", int(taskId)))
+			h.zapLogger.Error("Task not found", zap.String("pair", pair), zap.Int("task_id", int(taskId)))
 			c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
 			return
 		}
-		h.zapLogger.Error("get task", zap.String("pair", c.Param("PAIR")), zap.Int("task_id", int(taskId)), zap.Error(err))
+		h.zapLogger.Error("get task", zap.String("pair", pair), zap.Int("task_id", int(taskId)), zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get task"})
 		return
 	}
-	c.JSON(http.StatusOK, task)
+	h.renderPrices(c, http.StatusOK, task)
+}
+
+func (h *Handler) GetCurrencies(c *gin.Context) {
+	currencies, err := h.db.GetCurrencies(c.Request.Context())
+	if err != nil {
+		h.zapLogger.Error("get currencies", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get currencies"})
+		return
+	}
+	if currencies == nil {
+		currencies = []model.Currency{}
+	}
+	c.JSON(http.StatusOK, currencies)
+}
+
+func (h *Handler) GetCurrency(c *gin.Context) {
+	code := strings.ToUpper(c.Param("CODE"))
+	if _, ok := currency.Lookup(code); !ok {
+		h.zapLogger.Info("Unknown currency", zap.String("code", c.Param("CODE")))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown ISO 4217 currency code"})
+		return
+	}
+	supported, err := h.db.GetCurrency(c.Request.Context(), code)
+	if err != nil {
+		if errors.Is(err, db.ErrorNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Currency not supported by any provider"})
+			return
+		}
+		h.zapLogger.Error("get currency", zap.String("code", code), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get currency"})
+		return
+	}
+	c.JSON(http.StatusOK, supported)
 }
@@ -6,28 +6,45 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/GlazedCurd/PlataTest/internal/db"
 	"github.com/GlazedCurd/PlataTest/internal/model"
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/assert/v2"
+	"github.com/shopspring/decimal"
 	"go.uber.org/zap"
 )
 
 type dbMock struct {
-	getConflictedTask         func(ctx context.Context, idempotencyKey string, code model.Code) (*model.Task, error)
-	insertTask                func(ctx context.Context, task *model.Task) (*model.Task, error)
-	getTask                   func(ctx context.Context, code model.Code, taskId model.TaskId) (*model.Task, error)
-	taskTask                  func(ctx context.Context, task *model.Task) (*model.Task, error)
-	getLastSuccessfulTask     func(ctx context.Context, code model.Code) (*model.Task, error)
-	getRecentlyTasksToProcess func(ctx context.Context) ([]model.Task, error)
+	getConflictedTask        func(ctx context.Context, idempotencyKey string, code model.Code, asOf *model.Date) (*model.Task, error)
+	insertTask               func(ctx context.Context, task *model.Task) (*model.Task, error)
+	getTask                  func(ctx context.Context, code model.Code, taskId model.TaskId) (*model.Task, error)
+	taskTask                 func(ctx context.Context, task *model.Task) (*model.Task, error)
+	retryTask                func(ctx context.Context, task *model.Task, delay time.Duration) (*model.Task, error)
+	getLastSuccessfulTask    func(ctx context.Context, code model.Code) (*model.Task, error)
+	getLatestSuccessfulTasks func(ctx context.Context, currencies []string) ([]model.Task, error)
+	getSuccessfulTaskAsOf    func(ctx context.Context, code model.Code, day model.Date) (*model.Task, error)
+	getCandles               func(ctx context.Context, query model.CandleQuery) ([]model.Candle, error)
+	rollupCandles            func(ctx context.Context, interval model.CandleInterval) (int64, error)
+	getTaskHistory           func(ctx context.Context, query model.HistoryQuery) ([]model.Task, error)
+	claimTasks               func(ctx context.Context, workerId string, limit int, lease time.Duration) ([]model.Task, error)
+	releaseExpiredTasks      func(ctx context.Context) (int64, error)
+	releaseTasks             func(ctx context.Context, workerId string) (int64, error)
+	subscribeNewTasks        func(ctx context.Context) (<-chan struct{}, error)
+	getCurrencies            func(ctx context.Context) ([]model.Currency, error)
+	getCurrency              func(ctx context.Context, code string) (*model.Currency, error)
+	replaceCurrencies        func(ctx context.Context, currencies []model.Currency) (bool, error)
+	unsupportedCurrencies    func(ctx context.Context, codes []string) ([]string, error)
 }
 
 func NewDbMock() *dbMock {
 	return &dbMock{
-		getConflictedTask: func(ctx context.Context, idempotencyKey string, code model.Code) (*model.Task, error) {
+		getConflictedTask: func(ctx context.Context, idempotencyKey string, code model.Code, asOf *model.Date) (*model.Task, error) {
 			return nil, nil
 		},
 		insertTask: func(ctx context.Context, task *model.Task) (*model.Task, error) {
@@ -39,10 +56,49 @@ func NewDbMock() *dbMock {
 		taskTask: func(ctx context.Context, task *model.Task) (*model.Task, error) {
 			return nil, nil
 		},
+		retryTask: func(ctx context.Context, task *model.Task, delay time.Duration) (*model.Task, error) {
+			return nil, nil
+		},
 		getLastSuccessfulTask: func(ctx context.Context, code model.Code) (*model.Task, error) {
 			return nil, nil
 		},
-		getRecentlyTasksToProcess: func(ctx context.Context) ([]model.Task, error) {
+		getLatestSuccessfulTasks: func(ctx context.Context, currencies []string) ([]model.Task, error) {
+			return nil, nil
+		},
+		getSuccessfulTaskAsOf: func(ctx context.Context, code model.Code, day model.Date) (*model.Task, error) {
+			return nil, db.ErrorNotFound
+		},
+		getCandles: func(ctx context.Context, query model.CandleQuery) ([]model.Candle, error) {
+			return nil, nil
+		},
+		rollupCandles: func(ctx context.Context, interval model.CandleInterval) (int64, error) {
+			return 0, nil
+		},
+		getTaskHistory: func(ctx context.Context, query model.HistoryQuery) ([]model.Task, error) {
+			return nil, nil
+		},
+		claimTasks: func(ctx context.Context, workerId string, limit int, lease time.Duration) ([]model.Task, error) {
+			return nil, nil
+		},
+		releaseExpiredTasks: func(ctx context.Context) (int64, error) {
+			return 0, nil
+		},
+		releaseTasks: func(ctx context.Context, workerId string) (int64, error) {
+			return 0, nil
+		},
+		subscribeNewTasks: func(ctx context.Context) (<-chan struct{}, error) {
+			return nil, nil
+		},
+		getCurrencies: func(ctx context.Context) ([]model.Currency, error) {
+			return nil, nil
+		},
+		getCurrency: func(ctx context.Context, code string) (*model.Currency, error) {
+			return nil, db.ErrorNotFound
+		},
+		replaceCurrencies: func(ctx context.Context, currencies []model.Currency) (bool, error) {
+			return true, nil
+		},
+		unsupportedCurrencies: func(ctx context.Context, codes []string) ([]string, error) {
 			return nil, nil
 		},
 	}
@@ -52,8 +108,8 @@ func (d *dbMock) Close() error {
 	return nil
 }
 
-func (d *dbMock) GetConflictedTask(ctx context.Context, idempotencyKey string, code model.Code) (*model.Task, error) {
-	return d.getConflictedTask(ctx, idempotencyKey, code)
+func (d *dbMock) GetConflictedTask(ctx context.Context, idempotencyKey string, code model.Code, asOf *model.Date) (*model.Task, error) {
+	return d.getConflictedTask(ctx, idempotencyKey, code, asOf)
 }
 
 func (d *dbMock) InsertTask(ctx context.Context, task *model.Task) (*model.Task, error) {
@@ -68,12 +124,64 @@ func (d *dbMock) UpdateTask(ctx context.Context, task *model.Task) (*model.Task,
 	return d.taskTask(ctx, task)
 }
 
+func (d *dbMock) RetryTask(ctx context.Context, task *model.Task, delay time.Duration) (*model.Task, error) {
+	return d.retryTask(ctx, task, delay)
+}
+
 func (d *dbMock) GetLastSuccessfulTask(ctx context.Context, code model.Code) (*model.Task, error) {
 	return d.getLastSuccessfulTask(ctx, code)
 }
 
-func (d *dbMock) GetRecentlyTasksToProcess(ctx context.Context) ([]model.Task, error) {
-	return d.getRecentlyTasksToProcess(ctx)
+func (d *dbMock) GetLatestSuccessfulTasks(ctx context.Context, currencies []string) ([]model.Task, error) {
+	return d.getLatestSuccessfulTasks(ctx, currencies)
+}
+
+func (d *dbMock) GetSuccessfulTaskAsOf(ctx context.Context, code model.Code, day model.Date) (*model.Task, error) {
+	return d.getSuccessfulTaskAsOf(ctx, code, day)
+}
+
+func (d *dbMock) GetTaskHistory(ctx context.Context, query model.HistoryQuery) ([]model.Task, error) {
+	return d.getTaskHistory(ctx, query)
+}
+
+func (d *dbMock) GetCandles(ctx context.Context, query model.CandleQuery) ([]model.Candle, error) {
+	return d.getCandles(ctx, query)
+}
+
+func (d *dbMock) RollupCandles(ctx context.Context, interval model.CandleInterval) (int64, error) {
+	return d.rollupCandles(ctx, interval)
+}
+
+func (d *dbMock) ClaimTasks(ctx context.Context, workerId string, limit int, lease time.Duration) ([]model.Task, error) {
+	return d.claimTasks(ctx, workerId, limit, lease)
+}
+
+func (d *dbMock) ReleaseExpiredTasks(ctx context.Context) (int64, error) {
+	return d.releaseExpiredTasks(ctx)
+}
+
+func (d *dbMock) ReleaseTasks(ctx context.Context, workerId string) (int64, error) {
+	return d.releaseTasks(ctx, workerId)
+}
+
+func (d *dbMock) SubscribeNewTasks(ctx context.Context) (<-chan struct{}, error) {
+	return d.subscribeNewTasks(ctx)
+}
+
+func (d *dbMock) GetCurrencies(ctx context.Context) ([]model.Currency, error) {
+	return d.getCurrencies(ctx)
+}
+
+func (d *dbMock) GetCurrency(ctx context.Context, code string) (*model.Currency, error) {
+	return d.getCurrency(ctx, code)
+}
+
+func (d *dbMock) ReplaceCurrencies(ctx context.Context, currencies []model.Currency) (bool, error) {
+	return d.replaceCurrencies(ctx, currencies)
+}
+
+func (d *dbMock) UnsupportedCurrencies(ctx context.Context, codes []string) ([]string, error) {
+	return d.unsupportedCurrencies(ctx, codes)
 }
 
 func TestInsert(t *testing.T) {
@@ -81,7 +189,7 @@ func TestInsert(t *testing.T) {
 	dbmock := NewDbMock()
 
 	idempotencyKey := "abcd"
-	price := float64(0.0)
+	price := decimal.RequireFromString("1.170617")
 	taskExpected := &model.Task{
 		ID:             1,
 		IdempotencyKey: idempotencyKey,
@@ -102,7 +210,7 @@ func TestInsert(t *testing.T) {
 		t.Fatal("create logger")
 	}
 	defer logger.Sync()
-	SetupHandlers(r, dbmock, logger)
+	SetupHandlers(r, dbmock, Config{}, logger)
 
 	w := httptest.NewRecorder()
 
@@ -132,7 +240,7 @@ func TestGetLast(t *testing.T) {
 	dbmock := NewDbMock()
 
 	idempotencyKey := "abcd"
-	price := float64(0.0)
+	price := decimal.RequireFromString("1.170617")
 	taskExpected := &model.Task{
 		ID:             1,
 		IdempotencyKey: idempotencyKey,
@@ -150,7 +258,7 @@ func TestGetLast(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Creating logger %s", err)
 	}
-	SetupHandlers(r, dbmock, logger)
+	SetupHandlers(r, dbmock, Config{}, logger)
 
 	w := httptest.NewRecorder()
 
@@ -158,6 +266,7 @@ func TestGetLast(t *testing.T) {
 	req, _ := http.NewRequest("GET", fmt.Sprintf("/quotes/%s", pair), nil)
 	r.ServeHTTP(w, req)
 	assert.Equal(t, w.Code, 200)
+	assert.Equal(t, strings.Contains(w.Body.String(), `"price":"1.170617"`), true)
 	var response model.Task
 	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
 		t.Fatalf("Unmarshal response %s", err)
@@ -170,7 +279,7 @@ func TestGetSpec(t *testing.T) {
 	dbmock := NewDbMock()
 
 	idempotencyKey := "abcd"
-	price := float64(0.0)
+	price := decimal.RequireFromString("1.170617")
 	taskId := model.TaskId(1)
 	taskExpected := &model.Task{
 		ID:             taskId,
@@ -190,7 +299,7 @@ func TestGetSpec(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Creating logger %s", err)
 	}
-	SetupHandlers(r, dbmock, logger)
+	SetupHandlers(r, dbmock, Config{}, logger)
 
 	w := httptest.NewRecorder()
 
@@ -222,7 +331,7 @@ func TestInsertConflict(t *testing.T) {
 	if err != nil {
 		t.Fatal("failed to create logger")
 	}
-	SetupHandlers(r, dbmock, logger)
+	SetupHandlers(r, dbmock, Config{}, logger)
 
 	w := httptest.NewRecorder()
 
@@ -258,7 +367,7 @@ func TestGetSpecNotFound(t *testing.T) {
 	if err != nil {
 		t.Fatal("failed to create logger")
 	}
-	SetupHandlers(r, dbmock, logger)
+	SetupHandlers(r, dbmock, Config{}, logger)
 
 	w := httptest.NewRecorder()
 
@@ -267,3 +376,652 @@ func TestGetSpecNotFound(t *testing.T) {
 	r.ServeHTTP(w, req)
 	assert.Equal(t, w.Code, 404)
 }
+
+func TestInsertBodyTooLarge(t *testing.T) {
+	r := gin.Default()
+	r.Use(MaxBodySize(16))
+	dbmock := NewDbMock()
+
+	dbmock.insertTask = func(ctx context.Context, task *model.Task) (*model.Task, error) {
+		t.Fatal("task must not be inserted")
+		return nil, nil
+	}
+
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatal("failed to create logger")
+	}
+	SetupHandlers(r, dbmock, Config{}, logger)
+
+	w := httptest.NewRecorder()
+
+	pair := "EUR_USD"
+	body := fmt.Sprintf(`{"idempotency_key":"%s"}`, strings.Repeat("a", 64))
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/quotes/%s/task", pair), strings.NewReader(body))
+	r.ServeHTTP(w, req)
+	assert.Equal(t, w.Code, 413)
+}
+
+func TestInsertNormalizesPair(t *testing.T) {
+	for _, pair := range []string{"eurusd", "EUR%2FUSD", "eur-usd"} {
+		t.Run(pair, func(t *testing.T) {
+			r := gin.Default()
+			dbmock := NewDbMock()
+
+			dbmock.insertTask = func(ctx context.Context, task *model.Task) (*model.Task, error) {
+				assert.Equal(t, task.Code, "EUR_USD")
+				return task, nil
+			}
+
+			logger, err := zap.NewDevelopment()
+			if err != nil {
+				t.Fatal("failed to create logger")
+			}
+			SetupHandlers(r, dbmock, Config{}, logger)
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", fmt.Sprintf("/quotes/%s/task", pair), strings.NewReader(`{"idempotency_key":"abcd"}`))
+			r.ServeHTTP(w, req)
+			assert.Equal(t, w.Code, 200)
+		})
+	}
+}
+
+func TestInsertInvalidPair(t *testing.T) {
+	for _, pair := range []string{"foo", "EUR_ABC", "USD_USD"} {
+		t.Run(pair, func(t *testing.T) {
+			r := gin.Default()
+			dbmock := NewDbMock()
+
+			dbmock.insertTask = func(ctx context.Context, task *model.Task) (*model.Task, error) {
+				t.Fatal("task must not be inserted")
+				return nil, nil
+			}
+
+			logger, err := zap.NewDevelopment()
+			if err != nil {
+				t.Fatal("failed to create logger")
+			}
+			SetupHandlers(r, dbmock, Config{}, logger)
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", fmt.Sprintf("/quotes/%s/task", pair), strings.NewReader(`{"idempotency_key":"abcd"}`))
+			r.ServeHTTP(w, req)
+			assert.Equal(t, w.Code, 400)
+		})
+	}
+}
+
+func TestInsertUnsupportedCurrency(t *testing.T) {
+	r := gin.Default()
+	dbmock := NewDbMock()
+
+	dbmock.unsupportedCurrencies = func(ctx context.Context, codes []string) ([]string, error) {
+		assert.Equal(t, codes, []string{"EUR", "XPT"})
+		return []string{"XPT"}, nil
+	}
+	dbmock.insertTask = func(ctx context.Context, task *model.Task) (*model.Task, error) {
+		t.Fatal("task must not be inserted")
+		return nil, nil
+	}
+
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatal("failed to create logger")
+	}
+	SetupHandlers(r, dbmock, Config{}, logger)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/quotes/EUR_XPT/task", strings.NewReader(`{"idempotency_key":"abcd"}`))
+	r.ServeHTTP(w, req)
+	assert.Equal(t, w.Code, 400)
+}
+
+func TestGetCurrency(t *testing.T) {
+	minorUnits := 0
+	yen := &model.Currency{Code: "JPY", Name: "Yen", MinorUnits: &minorUnits, BaseProviders: []string{"ecb"}, TargetProviders: []string{"ecb"}}
+
+	tests := []struct {
+		code   string
+		status int
+	}{
+		{code: "jpy", status: 200},
+		{code: "HUF", status: 404},
+		{code: "ABC", status: 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			r := gin.Default()
+			dbmock := NewDbMock()
+
+			dbmock.getCurrency = func(ctx context.Context, code string) (*model.Currency, error) {
+				if code == "JPY" {
+					return yen, nil
+				}
+				return nil, db.ErrorNotFound
+			}
+
+			logger, err := zap.NewDevelopment()
+			if err != nil {
+				t.Fatal("failed to create logger")
+			}
+			SetupHandlers(r, dbmock, Config{}, logger)
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/currencies/"+tt.code, nil)
+			r.ServeHTTP(w, req)
+			assert.Equal(t, w.Code, tt.status)
+			if tt.status == 200 {
+				var response model.Currency
+				if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+					t.Fatalf("unmarshal response %s", err)
+				}
+				assert.Equal(t, &response, yen)
+			}
+		})
+	}
+}
+
+func TestGetHistory(t *testing.T) {
+	start := time.Date(2025, 8, 17, 0, 0, 0, 0, time.UTC)
+	var stored []model.Task
+	for i := range 5 {
+		price := decimal.New(int64(117+i), -2)
+		stored = append(stored, model.Task{
+			ID:      model.TaskId(i + 1),
+			Code:    "EUR_USD",
+			Price:   &price,
+			TaskdAt: start.Add(time.Duration(i) * time.Hour),
+			Status:  model.STATUS_SUCCESS,
+		})
+	}
+
+	r := gin.Default()
+	dbmock := NewDbMock()
+	dbmock.getTaskHistory = func(ctx context.Context, query model.HistoryQuery) ([]model.Task, error) {
+		assert.Equal(t, query.Code, "EUR_USD")
+		assert.Equal(t, query.Status, model.STATUS_SUCCESS)
+		assert.Equal(t, query.From, start)
+		var page []model.Task
+		for _, task := range stored {
+			if query.After != nil && !task.TaskdAt.After(query.After.UpdatedAt) {
+				continue
+			}
+			if len(page) < query.Limit {
+				page = append(page, task)
+			}
+		}
+		return page, nil
+	}
+
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatal("failed to create logger")
+	}
+	SetupHandlers(r, dbmock, Config{}, logger)
+
+	var ids []model.TaskId
+	cursor := ""
+	for range 3 {
+		w := httptest.NewRecorder()
+		url := "/quotes/eur-usd/history?from=2025-08-17T00:00:00Z&limit=2"
+		if cursor != "" {
+			url += "&cursor=" + cursor
+		}
+		req, _ := http.NewRequest("GET", url, nil)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, w.Code, 200)
+
+		var response model.QuoteHistory
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatalf("unmarshal response %s", err)
+		}
+		for _, task := range response.Quotes {
+			ids = append(ids, task.ID)
+		}
+		cursor = response.NextCursor
+		if cursor == "" {
+			break
+		}
+	}
+	assert.Equal(t, ids, []model.TaskId{1, 2, 3, 4, 5})
+	assert.Equal(t, cursor, "")
+}
+
+func TestGetHistoryInvalidQuery(t *testing.T) {
+	tests := []string{
+		"status=unknown",
+		"from=yesterday",
+		"from=2025-08-18T00:00:00Z&to=2025-08-17T00:00:00Z",
+		"limit=0",
+		"limit=100000",
+		"cursor=not-a-cursor",
+	}
+	for _, query := range tests {
+		t.Run(query, func(t *testing.T) {
+			r := gin.Default()
+			logger, err := zap.NewDevelopment()
+			if err != nil {
+				t.Fatal("failed to create logger")
+			}
+			SetupHandlers(r, NewDbMock(), Config{}, logger)
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/quotes/EUR_USD/history?"+query, nil)
+			r.ServeHTTP(w, req)
+			assert.Equal(t, w.Code, 400)
+		})
+	}
+}
+
+func TestGetAsOf(t *testing.T) {
+	day, _ := model.ParseDate("2025-08-16")
+	rateDate, _ := model.ParseDate("2025-08-15")
+	price := decimal.RequireFromString("1.1702")
+	taskExpected := &model.Task{
+		ID:       7,
+		Code:     "EUR_USD",
+		Price:    &price,
+		Status:   model.STATUS_SUCCESS,
+		AsOf:     &day,
+		RateDate: &rateDate,
+	}
+
+	tests := []struct {
+		query  string
+		status int
+	}{
+		{query: "as_of=2025-08-16", status: 200},
+		{query: "as_of=2025-08-01", status: 404},
+		{query: "as_of=16.08.2025", status: 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			r := gin.Default()
+			dbmock := NewDbMock()
+			dbmock.getSuccessfulTaskAsOf = func(ctx context.Context, code model.Code, asOf model.Date) (*model.Task, error) {
+				assert.Equal(t, code, "EUR_USD")
+				if asOf == day {
+					return taskExpected, nil
+				}
+				return nil, db.ErrorNotFound
+			}
+
+			logger, err := zap.NewDevelopment()
+			if err != nil {
+				t.Fatal("failed to create logger")
+			}
+			SetupHandlers(r, dbmock, Config{}, logger)
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/quotes/EUR_USD?"+tt.query, nil)
+			r.ServeHTTP(w, req)
+			assert.Equal(t, w.Code, tt.status)
+			if tt.status == 200 {
+				assert.Equal(t, strings.Contains(w.Body.String(), `"as_of":"2025-08-16","rate_date":"2025-08-15"`), true)
+				var response model.Task
+				if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+					t.Fatalf("unmarshal response %s", err)
+				}
+				assert.Equal(t, response, *taskExpected)
+			}
+		})
+	}
+}
+
+func TestInsertAsOf(t *testing.T) {
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{body: `{"idempotency_key":"abcd","as_of":"2025-08-15"}`, status: 200},
+		{body: `{"idempotency_key":"abcd","as_of":"` + time.Now().AddDate(0, 0, 2).Format(time.DateOnly) + `"}`, status: 400},
+		{body: `{"idempotency_key":"abcd","as_of":"15.08.2025"}`, status: 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.body, func(t *testing.T) {
+			r := gin.Default()
+			dbmock := NewDbMock()
+			dbmock.insertTask = func(ctx context.Context, task *model.Task) (*model.Task, error) {
+				assert.Equal(t, task.AsOf.String(), "2025-08-15")
+				return task, nil
+			}
+
+			logger, err := zap.NewDevelopment()
+			if err != nil {
+				t.Fatal("failed to create logger")
+			}
+			SetupHandlers(r, dbmock, Config{}, logger)
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/quotes/EUR_USD/task", strings.NewReader(tt.body))
+			r.ServeHTTP(w, req)
+			assert.Equal(t, w.Code, tt.status)
+		})
+	}
+}
+
+func TestGetCandles(t *testing.T) {
+	from := time.Date(2025, 8, 17, 0, 0, 0, 0, time.UTC)
+	candles := []model.Candle{
+		{
+			Time:  from,
+			Open:  decimal.RequireFromString("1.17"),
+			High:  decimal.RequireFromString("1.172"),
+			Low:   decimal.RequireFromString("1.169"),
+			Close: decimal.RequireFromString("1.171"),
+			Count: 4,
+		},
+	}
+
+	r := gin.Default()
+	dbmock := NewDbMock()
+	dbmock.getCandles = func(ctx context.Context, query model.CandleQuery) ([]model.Candle, error) {
+		assert.Equal(t, query.Code, "EUR_USD")
+		assert.Equal(t, query.Interval.Name, "4h")
+		assert.Equal(t, query.From, from)
+		assert.Equal(t, query.To, from.Add(24*time.Hour))
+		return candles, nil
+	}
+
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatal("failed to create logger")
+	}
+	SetupHandlers(r, dbmock, Config{}, logger)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/quotes/EUR_USD/ohlc?interval=4h&from=2025-08-17T00:00:00Z&to=2025-08-18T00:00:00Z", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, w.Code, 200)
+
+	var response model.Candles
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("unmarshal response %s", err)
+	}
+	assert.Equal(t, response, model.Candles{Code: "EUR_USD", Interval: "4h", Candles: candles})
+}
+
+func TestGetCandlesInvalidQuery(t *testing.T) {
+	tests := []string{
+		"interval=2h",
+		"from=yesterday",
+		"from=2025-08-18T00:00:00Z&to=2025-08-17T00:00:00Z",
+		"interval=1m&from=2024-08-17T00:00:00Z&to=2025-08-17T00:00:00Z",
+	}
+	for _, query := range tests {
+		t.Run(query, func(t *testing.T) {
+			r := gin.Default()
+			logger, err := zap.NewDevelopment()
+			if err != nil {
+				t.Fatal("failed to create logger")
+			}
+			SetupHandlers(r, NewDbMock(), Config{}, logger)
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/quotes/EUR_USD/ohlc?"+query, nil)
+			r.ServeHTTP(w, req)
+			assert.Equal(t, w.Code, 400)
+		})
+	}
+}
+
+func TestConvert(t *testing.T) {
+	updatedAt := time.Date(2025, 8, 17, 19, 31, 18, 0, time.UTC)
+	eurUsd := decimal.RequireFromString("1.17")
+	usdJpy := decimal.RequireFromString("147.5")
+	stored := map[string]*model.Task{
+		"EUR_USD": {ID: 4, Code: "EUR_USD", Price: &eurUsd, Status: model.STATUS_SUCCESS, TaskdAt: updatedAt},
+		"USD_JPY": {ID: 3, Code: "USD_JPY", Price: &usdJpy, Status: model.STATUS_SUCCESS, TaskdAt: updatedAt.Add(-time.Hour)},
+	}
+
+	tests := []struct {
+		query    string
+		status   int
+		result   string
+		rate     string
+		path     []string
+		taskIds  []model.TaskId
+		inverted []bool
+	}{
+		{query: "from=EUR&to=USD&amount=123.45", status: 200, result: "144.44", rate: "1.17", path: []string{"EUR", "USD"}, taskIds: []model.TaskId{4}, inverted: []bool{false}},
+		{query: "from=EUR&to=USD&amount=123.45&price_format=number", status: 200, result: "144.44", rate: "1.17", path: []string{"EUR", "USD"}, taskIds: []model.TaskId{4}, inverted: []bool{false}},
+		{query: "from=eur&to=usd&amount=123.45&rounding=down", status: 200, result: "144.43", rate: "1.17", path: []string{"EUR", "USD"}, taskIds: []model.TaskId{4}, inverted: []bool{false}},
+		// 100 * 1.17 * 147.5 = 17257.5 JPY, which has no minor units
+		{query: "from=EUR&to=JPY&amount=100", status: 200, result: "17258", rate: "172.575", path: []string{"EUR", "USD", "JPY"}, taskIds: []model.TaskId{4, 3}, inverted: []bool{false, false}},
+		{query: "from=USD&to=EUR&amount=117", status: 200, result: "100", rate: "0.854700854700855", path: []string{"USD", "EUR"}, taskIds: []model.TaskId{4}, inverted: []bool{true}},
+		{query: "from=EUR&to=GBP&amount=1", status: 404},
+		{query: "from=EUR&to=ABC&amount=1", status: 400},
+		{query: "from=EUR&to=USD&amount=many", status: 400},
+		{query: "from=EUR&to=USD&amount=1&rounding=nearest", status: 400},
+		{query: "from=EUR&to=USD&amount=1e50000000", status: 400},
+		{query: "from=EUR&to=USD&amount=1e-31", status: 400},
+		{query: "from=EUR&to=USD&amount=12345678901234567890123456789012345678901", status: 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			r := gin.Default()
+			dbmock := NewDbMock()
+			dbmock.getLastSuccessfulTask = func(ctx context.Context, code model.Code) (*model.Task, error) {
+				if task, ok := stored[code]; ok {
+					return task, nil
+				}
+				return nil, db.ErrorNotFound
+			}
+			dbmock.getLatestSuccessfulTasks = func(ctx context.Context, currencies []string) ([]model.Task, error) {
+				var tasks []model.Task
+				for _, task := range stored {
+					base, quote, _ := strings.Cut(task.Code, "_")
+					if slices.Contains(currencies, base) || slices.Contains(currencies, quote) {
+						tasks = append(tasks, *task)
+					}
+				}
+				return tasks, nil
+			}
+
+			logger, err := zap.NewDevelopment()
+			if err != nil {
+				t.Fatal("failed to create logger")
+			}
+			SetupHandlers(r, dbmock, Config{}, logger)
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/convert?"+tt.query, nil)
+			r.ServeHTTP(w, req)
+			assert.Equal(t, w.Code, tt.status)
+			if tt.status != 200 {
+				return
+			}
+			var response model.Conversion
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatalf("unmarshal response %s", err)
+			}
+			assert.Equal(t, response.Result.String(), tt.result)
+			assert.Equal(t, response.Rate.String(), tt.rate)
+			assert.Equal(t, response.Path, tt.path)
+			var taskIds []model.TaskId
+			var inverted []bool
+			for _, quote := range response.Quotes {
+				taskIds = append(taskIds, quote.TaskID)
+				inverted = append(inverted, quote.Inverse)
+			}
+			assert.Equal(t, taskIds, tt.taskIds)
+			assert.Equal(t, inverted, tt.inverted)
+			assert.Equal(t, response.Timestamp, stored[response.Quotes[len(response.Quotes)-1].Code].TaskdAt)
+		})
+	}
+}
+
+func TestConvertMaxQuoteAge(t *testing.T) {
+	now := time.Now()
+	eurUsd := decimal.RequireFromString("1.17")
+	usdEur := decimal.RequireFromString("0.85")
+	usdJpy := decimal.RequireFromString("147.5")
+
+	tests := []struct {
+		name   string
+		stored []model.Task
+		status int
+		taskId model.TaskId
+	}{
+		{
+			name:   "fresh",
+			stored: []model.Task{{ID: 1, Code: "EUR_USD", Price: &eurUsd, TaskdAt: now.Add(-time.Minute)}},
+			status: 200,
+			taskId: 1,
+		},
+		{
+			name:   "stale",
+			stored: []model.Task{{ID: 1, Code: "EUR_USD", Price: &eurUsd, TaskdAt: now.Add(-2 * time.Hour)}},
+			status: 404,
+		},
+		{
+			name: "stale pair with fresh inverse",
+			stored: []model.Task{
+				{ID: 1, Code: "EUR_USD", Price: &eurUsd, TaskdAt: now.Add(-2 * time.Hour)},
+				{ID: 2, Code: "USD_EUR", Price: &usdEur, TaskdAt: now.Add(-time.Minute)},
+				{ID: 3, Code: "USD_JPY", Price: &usdJpy, TaskdAt: now.Add(-2 * time.Hour)},
+			},
+			status: 200,
+			taskId: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.Default()
+			dbmock := NewDbMock()
+			dbmock.getLastSuccessfulTask = func(ctx context.Context, code model.Code) (*model.Task, error) {
+				for _, task := range tt.stored {
+					if task.Code == code {
+						return &task, nil
+					}
+				}
+				return nil, db.ErrorNotFound
+			}
+			dbmock.getLatestSuccessfulTasks = func(ctx context.Context, currencies []string) ([]model.Task, error) {
+				return slices.Clone(tt.stored), nil
+			}
+
+			logger, err := zap.NewDevelopment()
+			if err != nil {
+				t.Fatal("failed to create logger")
+			}
+			SetupHandlers(r, dbmock, Config{ConvertMaxQuoteAge: time.Hour}, logger)
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/convert?from=EUR&to=USD&amount=100", nil)
+			r.ServeHTTP(w, req)
+			assert.Equal(t, w.Code, tt.status)
+			if tt.status != 200 {
+				return
+			}
+			var response model.Conversion
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatalf("unmarshal response %s", err)
+			}
+			assert.Equal(t, len(response.Quotes), 1)
+			assert.Equal(t, response.Quotes[0].TaskID, tt.taskId)
+		})
+	}
+}
+
+func TestPriceFormat(t *testing.T) {
+	price := decimal.RequireFromString("1.170617")
+	contribution := decimal.RequireFromString("1.1706")
+	provider := "consensus"
+	task := &model.Task{
+		ID:             1,
+		IdempotencyKey: "1234",
+		Code:           "EUR_USD",
+		Price:          &price,
+		Status:         model.STATUS_SUCCESS,
+		Provider:       &provider,
+		Contributions:  []model.Contribution{{Provider: "ecb", Price: &contribution, Accepted: true}},
+	}
+
+	tests := []struct {
+		query  string
+		status int
+		parts  []string
+	}{
+		{query: "", status: 200, parts: []string{`"price":"1.170617"`, `"price":"1.1706"`}},
+		{query: "?price_format=string", status: 200, parts: []string{`"price":"1.170617"`, `"price":"1.1706"`}},
+		{query: "?price_format=number", status: 200, parts: []string{`"price":1.170617`, `"price":1.1706`, `"idempotency_key":"1234"`, `"provider":"consensus"`}},
+		{query: "?price_format=float", status: 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			r := gin.Default()
+			dbmock := NewDbMock()
+			dbmock.getLastSuccessfulTask = func(ctx context.Context, code model.Code) (*model.Task, error) {
+				return task, nil
+			}
+
+			logger, err := zap.NewDevelopment()
+			if err != nil {
+				t.Fatalf("Creating logger %s", err)
+			}
+			SetupHandlers(r, dbmock, Config{}, logger)
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/quotes/EUR_USD"+tt.query, nil)
+			r.ServeHTTP(w, req)
+			assert.Equal(t, w.Code, tt.status)
+			for _, part := range tt.parts {
+				assert.Equal(t, strings.Contains(w.Body.String(), part), true)
+			}
+			if tt.status != 200 {
+				return
+			}
+			var response model.Task
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatalf("Unmarshal response %s", err)
+			}
+			assert.Equal(t, response, *task)
+		})
+	}
+}
+
+func TestWithNumberPrices(t *testing.T) {
+	price := decimal.RequireFromString("1.17")
+	day := time.Date(2025, 8, 17, 0, 0, 0, 0, time.UTC)
+	task := model.Task{ID: 1, Code: "EUR_USD", Price: &price, Status: model.STATUS_SUCCESS, Attempts: 1,
+		Contributions: []model.Contribution{{Provider: "ecb", Price: &price, Accepted: true}, {Provider: "frankfurter", Error: "timeout"}}}
+
+	tests := []struct {
+		name  string
+		value any
+		parts []string
+	}{
+		{name: "task", value: &task, parts: []string{`"price":1.17`, `"accepted":true,"price":1.17`}},
+		{name: "history", value: model.QuoteHistory{Quotes: []model.Task{task}, NextCursor: "abc"}, parts: []string{`"price":1.17`, `"next_cursor":"abc"`}},
+		{name: "candles", value: model.Candles{Code: "EUR_USD", Interval: "1h", Candles: []model.Candle{{Time: day, Open: price, High: price, Low: price, Close: price, Count: 2}}},
+			parts: []string{`"open":1.17`, `"close":1.17`, `"count":2`}},
+		{name: "conversion", value: model.Conversion{From: "EUR", To: "USD", Amount: price, Result: price, Rate: price, Path: []string{"EUR", "USD"},
+			Quotes: []model.ConversionQuote{{TaskID: 1, Code: "EUR_USD", Price: price, UpdatedAt: day}}, Timestamp: day},
+			parts: []string{`"amount":1.17`, `"rate":1.17`, `"inverse":false,"updated_at":"2025-08-17T00:00:00Z","price":1.17`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			numbers, err := withNumberPrices(tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			raw, err := json.Marshal(numbers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, part := range tt.parts {
+				if !strings.Contains(string(raw), part) {
+					t.Errorf("%s doesn't contain %s", raw, part)
+				}
+			}
+			// Nothing but the format of the prices differs
+			decoded := reflect.New(reflect.TypeOf(tt.value))
+			if err := json.Unmarshal(raw, decoded.Interface()); err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, decoded.Elem().Interface(), tt.value)
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/GlazedCurd/PlataTest/internal/model"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 1000
+)
+
+var historyStatuses = []string{
+	model.STATUS_PENDING,
+	model.STATUS_PROCESSING,
+	model.STATUS_SUCCESS,
+	model.STATUS_FAILED,
+	model.STATUS_DEAD,
+}
+
+// encodeCursor makes an opaque page token out of the last task of a page.
+func encodeCursor(task model.Task) string {
+	raw, _ := json.Marshal(model.HistoryCursor{UpdatedAt: task.TaskdAt, ID: task.ID})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(cursor string) (*model.HistoryCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+	var res model.HistoryCursor
+	if err := json.Unmarshal(raw, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// historyQuery parses the query string of the history endpoint.
+func historyQuery(c *gin.Context, pair model.Code) (model.HistoryQuery, error) {
+	query := model.HistoryQuery{
+		Code:   pair,
+		Status: c.DefaultQuery("status", model.STATUS_SUCCESS),
+		Limit:  defaultHistoryLimit,
+	}
+	if !slices.Contains(historyStatuses, query.Status) {
+		return query, fmt.Errorf("invalid status %q", query.Status)
+	}
+	var err error
+	if from := c.Query("from"); from != "" {
+		if query.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return query, fmt.Errorf("invalid from: %w", err)
+		}
+	}
+	if to := c.Query("to"); to != "" {
+		if query.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return query, fmt.Errorf("invalid to: %w", err)
+		}
+	}
+	if !query.From.IsZero() && !query.To.IsZero() && !query.From.Before(query.To) {
+		return query, errors.New("from must be before to")
+	}
+	if limit := c.Query("limit"); limit != "" {
+		query.Limit, err = strconv.Atoi(limit)
+		if err != nil || query.Limit < 1 || query.Limit > maxHistoryLimit {
+			return query, fmt.Errorf("limit must be between 1 and %d", maxHistoryLimit)
+		}
+	}
+	if cursor := c.Query("cursor"); cursor != "" {
+		if query.After, err = decodeCursor(cursor); err != nil {
+			return query, errors.New("invalid cursor")
+		}
+	}
+	return query, nil
+}
+
+func (h *Handler) GetHistory(c *gin.Context) {
+	pair, ok := h.pairParam(c)
+	if !ok {
+		return
+	}
+	query, err := historyQuery(c, pair)
+	if err != nil {
+		h.zapLogger.Info("Invalid history query", zap.String("pair", pair), zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	h.zapLogger.Info("History requested", zap.String("pair", pair), zap.String("status", query.Status), zap.Int("limit", query.Limit))
+
+	// One extra task tells whether there is a next page
+	pageSize := query.Limit
+	query.Limit++
+	tasks, err := h.db.GetTaskHistory(c.Request.Context(), query)
+	if err != nil {
+		h.zapLogger.Error("get task history", zap.String("pair", pair), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get quote history"})
+		return
+	}
+
+	history := model.QuoteHistory{Quotes: tasks}
+	if len(tasks) > pageSize {
+		history.Quotes = tasks[:pageSize]
+		history.NextCursor = encodeCursor(tasks[pageSize-1])
+	}
+	if history.Quotes == nil {
+		history.Quotes = []model.Task{}
+	}
+	h.renderPrices(c, http.StatusOK, history)
+}
@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/GlazedCurd/PlataTest/internal/model"
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+)
+
+const (
+	// priceFormatString serializes prices as exact decimal strings.
+	priceFormatString = "string"
+	// priceFormatNumber serializes prices as JSON numbers for clients that
+	// can't take strings, at the risk of losing precision on their side.
+	priceFormatNumber = "number"
+
+	priceFormatKey = "price_format"
+)
+
+// PriceFormat validates the price_format query parameter of routes answering
+// with prices.
+func PriceFormat() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		format := c.DefaultQuery(priceFormatKey, priceFormatString)
+		if format != priceFormatString && format != priceFormatNumber {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid price_format %q", format)})
+			return
+		}
+		c.Set(priceFormatKey, format)
+		c.Next()
+	}
+}
+
+// renderPrices writes v as JSON with prices in the format the request asked for.
+func (h *Handler) renderPrices(c *gin.Context, status int, v any) {
+	if c.GetString(priceFormatKey) != priceFormatNumber {
+		c.JSON(status, v)
+		return
+	}
+	numbers, err := withNumberPrices(v)
+	if err != nil {
+		h.zapLogger.Error("render prices as numbers", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render response"})
+		return
+	}
+	c.JSON(status, numbers)
+}
+
+// withNumberPrices wraps a response so that its decimals are written as JSON
+// numbers.
+func withNumberPrices(v any) (any, error) {
+	switch v := v.(type) {
+	case *model.Task:
+		return numberTaskOf(v), nil
+	case model.QuoteHistory:
+		return numberQuoteHistoryOf(v), nil
+	case model.Candles:
+		return numberCandlesOf(v), nil
+	case model.Conversion:
+		return numberConversionOf(v), nil
+	default:
+		return nil, fmt.Errorf("no number prices for %T", v)
+	}
+}
+
+// numberDecimal marshals a decimal as a JSON number instead of a string.
+type numberDecimal struct {
+	decimal.Decimal
+}
+
+func (d numberDecimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func numberDecimalOf(d *decimal.Decimal) *numberDecimal {
+	if d == nil {
+		return nil
+	}
+	return &numberDecimal{*d}
+}
+
+// The response wrappers below embed a model type and shadow its decimal
+// fields, and the fields holding them, under the same JSON names. Outer
+// fields win over embedded ones in encoding/json.
+
+type numberTask struct {
+	*model.Task
+	Price         *numberDecimal       `json:"price,omitempty"`
+	Contributions []numberContribution `json:"contributions,omitempty"`
+}
+
+func numberTaskOf(task *model.Task) *numberTask {
+	if task == nil {
+		return nil
+	}
+	res := &numberTask{Task: task, Price: numberDecimalOf(task.Price)}
+	for _, contribution := range task.Contributions {
+		res.Contributions = append(res.Contributions, numberContribution{
+			Contribution: contribution,
+			Price:        numberDecimalOf(contribution.Price),
+		})
+	}
+	return res
+}
+
+type numberContribution struct {
+	model.Contribution
+	Price *numberDecimal `json:"price,omitempty"`
+}
+
+type numberQuoteHistory struct {
+	model.QuoteHistory
+	Quotes []*numberTask `json:"quotes"`
+}
+
+func numberQuoteHistoryOf(history model.QuoteHistory) numberQuoteHistory {
+	res := numberQuoteHistory{QuoteHistory: history}
+	if history.Quotes != nil {
+		res.Quotes = make([]*numberTask, 0, len(history.Quotes))
+	}
+	for i := range history.Quotes {
+		res.Quotes = append(res.Quotes, numberTaskOf(&history.Quotes[i]))
+	}
+	return res
+}
+
+type numberCandles struct {
+	Code     model.Code     `json:"code"`
+	Interval string         `json:"interval"`
+	Candles  []numberCandle `json:"candles"`
+}
+
+type numberCandle struct {
+	model.Candle
+	Open  numberDecimal `json:"open"`
+	High  numberDecimal `json:"high"`
+	Low   numberDecimal `json:"low"`
+	Close numberDecimal `json:"close"`
+}
+
+func numberCandlesOf(candles model.Candles) numberCandles {
+	res := numberCandles{Code: candles.Code, Interval: candles.Interval}
+	if candles.Candles != nil {
+		res.Candles = make([]numberCandle, 0, len(candles.Candles))
+	}
+	for _, candle := range candles.Candles {
+		res.Candles = append(res.Candles, numberCandle{
+			Candle: candle,
+			Open:   numberDecimal{candle.Open},
+			High:   numberDecimal{candle.High},
+			Low:    numberDecimal{candle.Low},
+			Close:  numberDecimal{candle.Close},
+		})
+	}
+	return res
+}
+
+type numberConversion struct {
+	model.Conversion
+	Amount numberDecimal           `json:"amount"`
+	Result numberDecimal           `json:"result"`
+	Rate   numberDecimal           `json:"rate"`
+	Quotes []numberConversionQuote `json:"quotes"`
+}
+
+type numberConversionQuote struct {
+	model.ConversionQuote
+	Price numberDecimal `json:"price"`
+}
+
+func numberConversionOf(conversion model.Conversion) numberConversion {
+	res := numberConversion{
+		Conversion: conversion,
+		Amount:     numberDecimal{conversion.Amount},
+		Result:     numberDecimal{conversion.Result},
+		Rate:       numberDecimal{conversion.Rate},
+	}
+	if conversion.Quotes != nil {
+		res.Quotes = make([]numberConversionQuote, 0, len(conversion.Quotes))
+	}
+	for _, quote := range conversion.Quotes {
+		res.Quotes = append(res.Quotes, numberConversionQuote{ConversionQuote: quote, Price: numberDecimal{quote.Price}})
+	}
+	return res
+}
@@ -1,21 +1,249 @@
 package model
 
-import "time"
+import (
+	"database/sql/driver"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
 
 type TaskId = uint64
 type Code = string
 
+// PriceScale is the number of decimal places prices are stored with, see the
+// quotes.quote column. Rates derived by division are rounded to it.
+const PriceScale = 15
+
 const (
-	STATUS_SUCCESS = "success"
-	STATUS_FAILED  = "failed"
+	STATUS_PENDING    = "pending"
+	STATUS_PROCESSING = "processing"
+	STATUS_SUCCESS    = "success"
+	STATUS_FAILED     = "failed"
+	STATUS_DEAD       = "dead"
 )
 
+// Error codes tell clients why a task failed or is being retried.
+const (
+	ERROR_PROVIDER_UNAVAILABLE = "provider_unavailable"
+	ERROR_RATE_LIMITED         = "rate_limited"
+	ERROR_UNSUPPORTED_PAIR     = "unsupported_pair"
+	ERROR_ACCESS_DENIED        = "access_denied"
+	ERROR_NO_CONSENSUS         = "no_consensus"
+	ERROR_PROVIDER_ERROR       = "provider_error"
+	ERROR_INVALID_PAIR         = "invalid_pair"
+	ERROR_ATTEMPTS_EXHAUSTED   = "attempts_exhausted"
+)
+
+// Date is a calendar day, written as YYYY-MM-DD in JSON and stored as DATE.
+type Date struct {
+	time.Time
+}
+
+// NewDate truncates t to its day in UTC.
+func NewDate(t time.Time) Date {
+	year, month, day := t.UTC().Date()
+	return Date{time.Date(year, month, day, 0, 0, 0, 0, time.UTC)}
+}
+
+// ParseDate parses a YYYY-MM-DD day.
+func ParseDate(value string) (Date, error) {
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return Date{}, err
+	}
+	return Date{t}, nil
+}
+
+func (d Date) String() string {
+	return d.Format(time.DateOnly)
+}
+
+func (d Date) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + d.String() + `"`), nil
+}
+
+func (d *Date) UnmarshalJSON(data []byte) error {
+	if len(data) < 2 || data[0] != '"' || data[len(data)-1] != '"' {
+		return fmt.Errorf("date must be a YYYY-MM-DD string, got %s", data)
+	}
+	parsed, err := ParseDate(string(data[1 : len(data)-1]))
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+func (d *Date) Scan(src any) error {
+	t, ok := src.(time.Time)
+	if !ok {
+		return fmt.Errorf("scan date from %T", src)
+	}
+	*d = NewDate(t)
+	return nil
+}
+
+func (d Date) Value() (driver.Value, error) {
+	return d.String(), nil
+}
+
+// Task is a quote request and its outcome. AsOf is the day a historical quote
+// is requested for, nil for the latest rate; RateDate is the day the fetched
+// rate is effective on, as reported by the provider.
 type Task struct {
-	ID             TaskId    `json:"id,omitempty"`
-	Price          *float64  `json:"price,omitempty"`
-	Code           Code      `json:"code,omitempty"`
-	IdempotencyKey string    `json:"idempotency_key,omitempty"`
-	CreatedAt      time.Time `json:"created_at,omitempty"`
-	TaskdAt        time.Time `json:"updated_at,omitempty"`
-	Status         string    `json:"status,omitempty"`
+	ID             TaskId           `json:"id,omitempty"`
+	Price          *decimal.Decimal `json:"price,omitempty"`
+	Code           Code             `json:"code,omitempty"`
+	IdempotencyKey string           `json:"idempotency_key,omitempty"`
+	CreatedAt      time.Time        `json:"created_at,omitempty"`
+	TaskdAt        time.Time        `json:"updated_at,omitempty"`
+	Status         string           `json:"status,omitempty"`
+	Provider       *string          `json:"provider,omitempty"`
+	ConversionPath []string         `json:"conversion_path,omitempty"`
+	Contributions  []Contribution   `json:"contributions,omitempty"`
+	ErrorCode      *string          `json:"error_code,omitempty"`
+	LastError      *string          `json:"error_message,omitempty"`
+	Attempts       int              `json:"attempts"`
+	AsOf           *Date            `json:"as_of,omitempty"`
+	RateDate       *Date            `json:"rate_date,omitempty"`
+	ClaimedBy      *string          `json:"-"`
+	LeaseExpiresAt *time.Time       `json:"-"`
+	NextAttemptAt  *time.Time       `json:"-"`
+}
+
+// Contribution is one provider's answer in a consensus quote.
+type Contribution struct {
+	Provider string           `json:"provider"`
+	Price    *decimal.Decimal `json:"price,omitempty"`
+	// Accepted is false for failed providers and outliers.
+	Accepted bool   `json:"accepted"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Currency is an entry of the supported currencies catalog.
+type Currency struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+	// MinorUnits is absent for currencies without a minor unit, e.g. gold.
+	MinorUnits      *int     `json:"minor_units,omitempty"`
+	BaseProviders   []string `json:"base_providers"`
+	TargetProviders []string `json:"target_providers"`
+}
+
+// HistoryQuery selects one page of a pair's tasks ordered by (updated_at, id).
+type HistoryQuery struct {
+	Code   Code
+	Status string
+	// From is inclusive and To is exclusive, zero values leave the range open.
+	From time.Time
+	To   time.Time
+	// After is the position of the last task of the previous page.
+	After *HistoryCursor
+	Limit int
+}
+
+// HistoryCursor is a keyset position in the quote history.
+type HistoryCursor struct {
+	UpdatedAt time.Time `json:"updated_at"`
+	ID        TaskId    `json:"id"`
+}
+
+// QuoteHistory is one page of the quote history.
+type QuoteHistory struct {
+	Quotes []Task `json:"quotes"`
+	// NextCursor is absent on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// CandleInterval is the width of a candle: Count date_trunc Units. Counts
+// divide the next larger unit, so candles never straddle it.
+type CandleInterval struct {
+	Name  string
+	Unit  string
+	Count int
+}
+
+// CandleIntervals are the supported candle widths.
+var CandleIntervals = []CandleInterval{
+	{Name: "1m", Unit: "minute", Count: 1},
+	{Name: "5m", Unit: "minute", Count: 5},
+	{Name: "15m", Unit: "minute", Count: 15},
+	{Name: "30m", Unit: "minute", Count: 30},
+	{Name: "1h", Unit: "hour", Count: 1},
+	{Name: "4h", Unit: "hour", Count: 4},
+	{Name: "1d", Unit: "day", Count: 1},
+}
+
+// ParseCandleInterval looks up a supported candle width by name, e.g. "1h".
+func ParseCandleInterval(name string) (CandleInterval, bool) {
+	for _, interval := range CandleIntervals {
+		if interval.Name == name {
+			return interval, true
+		}
+	}
+	return CandleInterval{}, false
+}
+
+func (i CandleInterval) Duration() time.Duration {
+	switch i.Unit {
+	case "minute":
+		return time.Duration(i.Count) * time.Minute
+	case "hour":
+		return time.Duration(i.Count) * time.Hour
+	default:
+		return time.Duration(i.Count) * 24 * time.Hour
+	}
+}
+
+// Candle aggregates the successful quotes updated within one interval.
+type Candle struct {
+	Time  time.Time       `json:"time"`
+	Open  decimal.Decimal `json:"open"`
+	High  decimal.Decimal `json:"high"`
+	Low   decimal.Decimal `json:"low"`
+	Close decimal.Decimal `json:"close"`
+	Count int             `json:"count"`
+}
+
+// CandleQuery selects the candles of a pair starting within [From, To).
+type CandleQuery struct {
+	Code     Code
+	Interval CandleInterval
+	From     time.Time
+	To       time.Time
+}
+
+// Candles is the OHLC series of a pair.
+type Candles struct {
+	Code     Code     `json:"code"`
+	Interval string   `json:"interval"`
+	Candles  []Candle `json:"candles"`
+}
+
+// Conversion is an amount converted at the latest stored rates.
+type Conversion struct {
+	From   Code            `json:"from"`
+	To     Code            `json:"to"`
+	Amount decimal.Decimal `json:"amount"`
+	// Result is Amount times Rate rounded to the minor unit of To.
+	Result   decimal.Decimal `json:"result"`
+	Rate     decimal.Decimal `json:"rate"`
+	Rounding string          `json:"rounding"`
+	// Path lists the currencies a triangulated rate was derived through, both ends included.
+	Path []string `json:"path"`
+	// Quotes are the stored quotes the rate was derived from.
+	Quotes []ConversionQuote `json:"quotes"`
+	// Timestamp is when the oldest of Quotes was fetched.
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ConversionQuote is a stored quote used for a conversion.
+type ConversionQuote struct {
+	TaskID TaskId          `json:"task_id"`
+	Code   Code            `json:"code"`
+	Price  decimal.Decimal `json:"price"`
+	// Inverse is set when the quote was used backwards, e.g. USD_EUR to convert EUR to USD.
+	Inverse   bool      `json:"inverse"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -0,0 +1,181 @@
+package quotafetcher
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+)
+
+// ErrCircuitOpen is returned without calling the provider while its breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// breakerMetrics is published at /debug/vars as
+// {"quotafetcher_circuit_breakers": {"<provider>": {"state": "open", "opened": 3, "rejected": 42}}}.
+var breakerMetrics = expvar.NewMap("quotafetcher_circuit_breakers")
+
+type BreakerState int
+
+const (
+	// BreakerClosed lets every request through.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails requests fast until OpenTimeout passes.
+	BreakerOpen
+	// BreakerHalfOpen lets a single trial request through to probe the provider.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	default:
+		return "half-open"
+	}
+}
+
+// BreakerConfig tunes NewCircuitBreakerQuotaFetcher.
+type BreakerConfig struct {
+	// FailureThreshold is the number of consecutive failed requests that opens the breaker.
+	FailureThreshold int
+	// OpenTimeout is how long the breaker stays open before a trial request.
+	OpenTimeout time.Duration
+}
+
+type circuitBreakerQuotaFetcher struct {
+	name   string
+	inner  BatchQuotaFetcher
+	cfg    BreakerConfig
+	logger *zap.Logger
+	now    func() time.Time
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	trial    bool
+
+	stateMetric    *expvar.String
+	openedMetric   *expvar.Int
+	rejectedMetric *expvar.Int
+}
+
+// NewCircuitBreakerQuotaFetcher stops calling a provider that keeps failing
+// with transient errors, so a sick provider fails fast instead of tying up
+// workers with retries. Answers like "unsupported pair" mean the provider is
+// alive and don't count as failures.
+func NewCircuitBreakerQuotaFetcher(name string, inner BatchQuotaFetcher, cfg BreakerConfig, logger *zap.Logger) BatchQuotaFetcher {
+	metrics := new(expvar.Map).Init()
+	b := &circuitBreakerQuotaFetcher{
+		name:           name,
+		inner:          inner,
+		cfg:            cfg,
+		logger:         logger.With(zap.String("provider", name)),
+		now:            time.Now,
+		stateMetric:    new(expvar.String),
+		openedMetric:   new(expvar.Int),
+		rejectedMetric: new(expvar.Int),
+	}
+	b.stateMetric.Set(BreakerClosed.String())
+	metrics.Set("state", b.stateMetric)
+	metrics.Set("opened", b.openedMetric)
+	metrics.Set("rejected", b.rejectedMetric)
+	breakerMetrics.Set(name, metrics)
+	return b
+}
+
+func (b *circuitBreakerQuotaFetcher) FetchQuota(ctx context.Context, code string, logger *zap.Logger) (decimal.Decimal, error) {
+	return fetchOne(ctx, b, code, logger)
+}
+
+func (b *circuitBreakerQuotaFetcher) FetchQuotas(ctx context.Context, req BatchRequest, logger *zap.Logger) map[string]Result {
+	if !b.allow() {
+		b.rejectedMetric.Add(1)
+		return failAll(req, fmt.Errorf("%w: %s", ErrCircuitOpen, b.name))
+	}
+	results := b.inner.FetchQuotas(ctx, req, logger)
+	if ctx.Err() != nil {
+		// Our own cancellation says nothing about the provider
+		b.release()
+		return results
+	}
+	b.record(providerFailed(results))
+	return results
+}
+
+// providerFailed tells whether the provider gave no useful answer at all.
+func providerFailed(results map[string]Result) bool {
+	for _, result := range results {
+		if result.Err == nil || Classify(result.Err) != ClassRetryable {
+			return false
+		}
+	}
+	return len(results) > 0
+}
+
+func (b *circuitBreakerQuotaFetcher) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if b.now().Sub(b.openedAt) < b.cfg.OpenTimeout {
+			return false
+		}
+		b.setState(BreakerHalfOpen)
+		b.trial = true
+		return true
+	case BreakerHalfOpen:
+		if b.trial {
+			return false
+		}
+		b.trial = true
+		return true
+	default:
+		return true
+	}
+}
+
+// release gives up a half-open trial without judging the provider.
+func (b *circuitBreakerQuotaFetcher) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+func (b *circuitBreakerQuotaFetcher) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+	if !failed {
+		b.failures = 0
+		if b.state != BreakerClosed {
+			b.setState(BreakerClosed)
+		}
+		return
+	}
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.cfg.FailureThreshold {
+		b.openedAt = b.now()
+		b.openedMetric.Add(1)
+		b.setState(BreakerOpen)
+	}
+}
+
+func (b *circuitBreakerQuotaFetcher) setState(state BreakerState) {
+	if b.state == state {
+		return
+	}
+	b.logger.Warn("Circuit breaker state changed",
+		zap.String("from", b.state.String()),
+		zap.String("to", state.String()),
+		zap.Int("failures", b.failures))
+	b.state = state
+	b.stateMetric.Set(state.String())
+}
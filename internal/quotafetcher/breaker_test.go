@@ -0,0 +1,53 @@
+package quotafetcher
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	calls := 0
+	down := true
+	inner := &batchMock{
+		fetchQuotas: func(ctx context.Context, req BatchRequest, logger *zap.Logger) map[string]Result {
+			calls++
+			if down {
+				return failAll(req, errors.New("server error: 503 Service Unavailable"))
+			}
+			return map[string]Result{"USD": {Price: decimal.RequireFromString("1.17")}}
+		},
+	}
+	now := time.Now()
+	fetcher := NewCircuitBreakerQuotaFetcher("test", inner, BreakerConfig{FailureThreshold: 2, OpenTimeout: time.Minute}, zap.NewNop())
+	breaker := fetcher.(*circuitBreakerQuotaFetcher)
+	breaker.now = func() time.Time { return now }
+	req := BatchRequest{Base: "EUR", Targets: []string{"USD"}}
+
+	fetcher.FetchQuotas(context.Background(), req, zap.NewNop())
+	fetcher.FetchQuotas(context.Background(), req, zap.NewNop())
+	assert.Equal(t, breaker.state, BreakerOpen)
+
+	// Open breaker fails fast without calling the provider
+	result := fetcher.FetchQuotas(context.Background(), req, zap.NewNop())["USD"]
+	assert.Equal(t, errors.Is(result.Err, ErrCircuitOpen), true)
+	assert.Equal(t, calls, 2)
+
+	// Failed trial opens it again
+	now = now.Add(time.Minute)
+	fetcher.FetchQuotas(context.Background(), req, zap.NewNop())
+	assert.Equal(t, calls, 3)
+	assert.Equal(t, breaker.state, BreakerOpen)
+
+	// Successful trial closes it
+	now = now.Add(time.Minute)
+	down = false
+	result = fetcher.FetchQuotas(context.Background(), req, zap.NewNop())["USD"]
+	assertPrice(t, result.Price, "1.17")
+	assert.Equal(t, breaker.state, BreakerClosed)
+}
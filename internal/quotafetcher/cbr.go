@@ -0,0 +1,148 @@
+package quotafetcher
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/GlazedCurd/PlataTest/internal/model"
+	"github.com/GlazedCurd/PlataTest/internal/retry"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/time/rate"
+)
+
+const cbrBaseCurrency = "RUB"
+
+const (
+	// cbrRequestDateLayout is the format of the date_req parameter.
+	cbrRequestDateLayout = "02/01/2006"
+	// cbrDateLayout is the format of the ValCurs Date attribute.
+	cbrDateLayout = "02.01.2006"
+)
+
+// cbrQuotaFetcher quotes any pair via RUB using the Central Bank of Russia
+// official daily rates.
+type cbrQuotaFetcher struct {
+	httpSource
+	baseUrl string
+}
+
+// cbrValCurs is XML_daily.asp, windows-1251 encoded with comma decimals.
+// Value is the price in RUB of Nominal units of the currency:
+//
+//	<ValCurs Date="15.08.2025" name="Foreign Currency Market">
+//	    <Valute ID="R01820">
+//	        <CharCode>JPY</CharCode>
+//	        <Nominal>100</Nominal>
+//	        <Value>54,1030</Value>
+type cbrValCurs struct {
+	Date    string `xml:"Date,attr"`
+	Valutes []struct {
+		CharCode string `xml:"CharCode"`
+		Nominal  string `xml:"Nominal"`
+		Value    string `xml:"Value"`
+	} `xml:"Valute"`
+}
+
+// NewCBRQuotaFetcher creates a fetcher reading XML_daily.asp under baseUrl,
+// e.g. https://www.cbr.ru/scripts/. Historical rates come from the same page
+// with date_req.
+func NewCBRQuotaFetcher(httpClient *http.Client, limiter *rate.Limiter, baseUrl string, policy retry.Policy) BatchQuotaFetcher {
+	return &cbrQuotaFetcher{
+		httpSource: newHTTPSource(httpClient, limiter, policy),
+		baseUrl:    baseUrl,
+	}
+}
+
+func cbrCharsetReader(charset string, input io.Reader) (io.Reader, error) {
+	if strings.EqualFold(charset, "windows-1251") {
+		return charmap.Windows1251.NewDecoder().Reader(input), nil
+	}
+	return nil, fmt.Errorf("unsupported charset: %s", charset)
+}
+
+// parseCBRNumber parses numbers with a comma decimal separator, e.g. "79,7653".
+func parseCBRNumber(value string) (decimal.Decimal, error) {
+	return decimal.NewFromString(strings.Replace(strings.TrimSpace(value), ",", ".", 1))
+}
+
+// fetchRubRates returns the amount of each currency per 1 RUB, RUB included,
+// and the day they are effective on. A zero asOf asks for the latest rates.
+func (q *cbrQuotaFetcher) fetchRubRates(ctx context.Context, asOf model.Date, logger *zap.Logger) (map[string]decimal.Decimal, model.Date, error) {
+	u, err := url.Parse(q.baseUrl)
+	if err != nil {
+		return nil, model.Date{}, fmt.Errorf("parse base URL: %w", err)
+	}
+	u = u.JoinPath("XML_daily.asp")
+	if !asOf.IsZero() {
+		query := u.Query()
+		query.Set("date_req", asOf.Format(cbrRequestDateLayout))
+		u.RawQuery = query.Encode()
+	}
+
+	var valCurs cbrValCurs
+	err = q.get(ctx, u, func(resp *http.Response) error {
+		if err := expectOK(resp); err != nil {
+			return err
+		}
+		decoder := xml.NewDecoder(resp.Body)
+		decoder.CharsetReader = cbrCharsetReader
+		if err := decoder.Decode(&valCurs); err != nil {
+			return fmt.Errorf("decode daily rates: %w: %w", err, ErrNonRetryable)
+		}
+		return nil
+	}, logger)
+	if err != nil {
+		return nil, model.Date{}, err
+	}
+	date, err := time.Parse(cbrDateLayout, valCurs.Date)
+	if err != nil {
+		return nil, model.Date{}, fmt.Errorf("parse daily rates date %q: %w: %w", valCurs.Date, err, ErrNonRetryable)
+	}
+
+	rates := make(map[string]decimal.Decimal, len(valCurs.Valutes)+1)
+	rates[cbrBaseCurrency] = decimal.NewFromInt(1)
+	for _, valute := range valCurs.Valutes {
+		nominal, err := parseCBRNumber(valute.Nominal)
+		if err != nil {
+			return nil, model.Date{}, fmt.Errorf("parse %s nominal %q: %w: %w", valute.CharCode, valute.Nominal, err, ErrNonRetryable)
+		}
+		value, err := parseCBRNumber(valute.Value)
+		if err != nil {
+			return nil, model.Date{}, fmt.Errorf("parse %s value %q: %w: %w", valute.CharCode, valute.Value, err, ErrNonRetryable)
+		}
+		if value.IsZero() {
+			continue
+		}
+		rates[valute.CharCode] = nominal.DivRound(value, pivotPrecision)
+	}
+	logger.Info("Fetched CBR daily rates", zap.String("date", valCurs.Date), zap.Int("count", len(valCurs.Valutes)))
+	return rates, model.NewDate(date), nil
+}
+
+func (q *cbrQuotaFetcher) FetchQuota(ctx context.Context, code string, logger *zap.Logger) (decimal.Decimal, error) {
+	return fetchOne(ctx, q, code, logger)
+}
+
+func (q *cbrQuotaFetcher) FetchQuotas(ctx context.Context, req BatchRequest, logger *zap.Logger) map[string]Result {
+	rubRates, date, err := q.fetchRubRates(ctx, req.AsOf, logger)
+	if err != nil {
+		return failAll(req, err)
+	}
+	return crossRates(req, rubRates, date)
+}
+
+func (q *cbrQuotaFetcher) ListSymbols(ctx context.Context, logger *zap.Logger) (Symbols, error) {
+	rubRates, _, err := q.fetchRubRates(ctx, model.Date{}, logger)
+	if err != nil {
+		return Symbols{}, err
+	}
+	return symbolsOf(rubRates), nil
+}
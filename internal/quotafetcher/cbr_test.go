@@ -0,0 +1,55 @@
+package quotafetcher
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/GlazedCurd/PlataTest/internal/model"
+	"github.com/GlazedCurd/PlataTest/internal/retry"
+	"github.com/go-playground/assert/v2"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+func TestCBRFetchQuotas(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer server.Close()
+
+	fetcher := NewCBRQuotaFetcher(server.Client(), rate.NewLimiter(rate.Inf, 1), server.URL, retry.Policy{MaxAttempts: 1})
+
+	results := fetcher.FetchQuotas(context.Background(), BatchRequest{Base: "USD", Targets: []string{"RUB", "EUR", "JPY", "XXX"}}, zap.NewNop())
+	assertPrice(t, results["RUB"].Price, "80")
+	assert.Equal(t, results["EUR"].Err, nil)
+	// 80 / 93.6 rounded to 15 places
+	assertPrice(t, results["EUR"].Price, "0.854700854700855")
+	// 100 JPY cost 54.40 RUB: 80 * 100 / 54.4
+	assertPrice(t, results["JPY"].Price, "147.058823529411765")
+	assert.Equal(t, Classify(results["XXX"].Err), ClassUnsupportedPair)
+
+	quota, err := fetcher.FetchQuota(context.Background(), "RUB_USD", zap.NewNop())
+	if err != nil {
+		t.Fatalf("fetch quota %s", err)
+	}
+	assertPrice(t, quota, "0.0125")
+}
+
+func TestCBRHistoricalRates(t *testing.T) {
+	files := http.FileServer(http.Dir("testdata"))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.URL.Query().Get("date_req"), "16/08/2025")
+		files.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	fetcher := NewCBRQuotaFetcher(server.Client(), rate.NewLimiter(rate.Inf, 1), server.URL, retry.Policy{MaxAttempts: 1})
+
+	asOf, _ := model.ParseDate("2025-08-16")
+	result := fetcher.FetchQuotas(context.Background(), BatchRequest{Base: "USD", Targets: []string{"RUB"}, AsOf: asOf}, zap.NewNop())["RUB"]
+	if result.Err != nil {
+		t.Fatalf("fetch quotas %s", result.Err)
+	}
+	assertPrice(t, result.Price, "80")
+	assert.Equal(t, result.Date.String(), "2025-08-15")
+}
@@ -0,0 +1,136 @@
+package quotafetcher
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/GlazedCurd/PlataTest/internal/model"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+)
+
+// ConsensusConfig tunes NewConsensusQuotaFetcher.
+type ConsensusConfig struct {
+	// Quorum is the minimal number of providers that must agree on a rate.
+	Quorum int
+	// MaxDeviation is the relative distance from the median, e.g. 0.005 for 0.5%,
+	// beyond which a provider's rate is rejected as an outlier.
+	MaxDeviation float64
+}
+
+type consensusQuotaFetcher struct {
+	providers []Provider
+	cfg       ConsensusConfig
+}
+
+// NewConsensusQuotaFetcher asks all providers concurrently and returns the
+// median of the rates that agree with each other. Results carry every
+// provider's contribution.
+func NewConsensusQuotaFetcher(providers []Provider, cfg ConsensusConfig) BatchQuotaFetcher {
+	return &consensusQuotaFetcher{providers: providers, cfg: cfg}
+}
+
+func (q *consensusQuotaFetcher) FetchQuota(ctx context.Context, code string, logger *zap.Logger) (decimal.Decimal, error) {
+	return fetchOne(ctx, q, code, logger)
+}
+
+func (q *consensusQuotaFetcher) FetchQuotas(ctx context.Context, req BatchRequest, logger *zap.Logger) map[string]Result {
+	providerResults := make([]map[string]Result, len(q.providers))
+	var wg sync.WaitGroup
+	for i, provider := range q.providers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			providerResults[i] = provider.Fetcher.FetchQuotas(ctx, req, logger.With(zap.String("provider", provider.Name)))
+		}()
+	}
+	wg.Wait()
+
+	results := make(map[string]Result, len(req.Targets))
+	for _, target := range req.Targets {
+		var contributions []model.Contribution
+		var failures []providerError
+		for i, provider := range q.providers {
+			result, ok := providerResults[i][target]
+			if !ok {
+				result.Err = fmt.Errorf("no result for currency: %s", target)
+			}
+			if result.Err != nil {
+				failures = append(failures, providerError{provider: provider.Name, err: result.Err})
+				contributions = append(contributions, model.Contribution{Provider: provider.Name, Error: result.Err.Error()})
+				continue
+			}
+			price := result.Price
+			contributions = append(contributions, model.Contribution{Provider: provider.Name, Price: &price})
+		}
+		result := q.agree(ctx, contributions, failures)
+		if result.Err != nil {
+			logger.Warn("No consensus", zap.String("target", target), zap.Error(result.Err))
+		} else {
+			// Contributions follow the order of providers
+			var dates []model.Date
+			for i, contribution := range result.Contributions {
+				if contribution.Accepted {
+					dates = append(dates, providerResults[i][target].Date)
+				}
+			}
+			result.Date = earliestDate(dates...)
+		}
+		results[target] = result
+	}
+	return results
+}
+
+// agree rejects outliers around the median and checks the quorum.
+func (q *consensusQuotaFetcher) agree(ctx context.Context, contributions []model.Contribution, failures []providerError) Result {
+	var prices []decimal.Decimal
+	for _, contribution := range contributions {
+		if contribution.Price != nil {
+			prices = append(prices, *contribution.Price)
+		}
+	}
+	if len(prices) == 0 {
+		return Result{Contributions: contributions, Err: chainError(ctx, failures)}
+	}
+
+	center := median(prices)
+	maxDeviation := center.Mul(decimal.NewFromFloat(q.cfg.MaxDeviation))
+	var accepted []decimal.Decimal
+	var providers []string
+	for i, contribution := range contributions {
+		if contribution.Price == nil {
+			continue
+		}
+		if contribution.Price.Sub(center).Abs().LessThanOrEqual(maxDeviation) {
+			contributions[i].Accepted = true
+			accepted = append(accepted, *contribution.Price)
+			providers = append(providers, contribution.Provider)
+		}
+	}
+
+	if len(accepted) < q.cfg.Quorum {
+		// Disagreement or missing providers may well be temporary, so it's worth another attempt
+		return Result{
+			Contributions: contributions,
+			Err:           fmt.Errorf("quorum not reached: %d of %d providers agree, %d required: %w", len(accepted), len(contributions), q.cfg.Quorum, ErrNoConsensus),
+		}
+	}
+	return Result{
+		Price:         median(accepted),
+		Provider:      strings.Join(providers, ","),
+		Contributions: contributions,
+	}
+}
+
+func median(values []decimal.Decimal) decimal.Decimal {
+	sorted := slices.Clone(values)
+	slices.SortFunc(sorted, decimal.Decimal.Cmp)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return sorted[middle-1].Add(sorted[middle]).Div(decimal.NewFromInt(2))
+	}
+	return sorted[middle]
+}
@@ -0,0 +1,40 @@
+package quotafetcher
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	"go.uber.org/zap"
+)
+
+func TestConsensusRejectsOutlier(t *testing.T) {
+	fetcher := NewConsensusQuotaFetcher([]Provider{
+		staticProvider("a", map[string]float64{"USD": 1.170}, nil),
+		staticProvider("b", map[string]float64{"USD": 1.172}, nil),
+		staticProvider("c", map[string]float64{"USD": 1.300}, nil),
+		staticProvider("d", nil, map[string]error{"USD": errors.New("server error")}),
+	}, ConsensusConfig{Quorum: 2, MaxDeviation: 0.01})
+
+	result := fetcher.FetchQuotas(context.Background(), BatchRequest{Base: "EUR", Targets: []string{"USD"}}, zap.NewNop())["USD"]
+
+	assert.Equal(t, result.Err, nil)
+	assertPrice(t, result.Price, "1.171")
+	assert.Equal(t, result.Provider, "a,b")
+	assert.Equal(t, len(result.Contributions), 4)
+	assert.Equal(t, result.Contributions[2].Accepted, false)
+	assert.Equal(t, result.Contributions[3].Error, "server error")
+}
+
+func TestConsensusNoQuorum(t *testing.T) {
+	fetcher := NewConsensusQuotaFetcher([]Provider{
+		staticProvider("a", map[string]float64{"USD": 1.17}, nil),
+		staticProvider("b", nil, map[string]error{"USD": errors.New("server error")}),
+	}, ConsensusConfig{Quorum: 2, MaxDeviation: 0.01})
+
+	result := fetcher.FetchQuotas(context.Background(), BatchRequest{Base: "EUR", Targets: []string{"USD"}}, zap.NewNop())["USD"]
+
+	assert.Equal(t, result.Err != nil, true)
+	assert.Equal(t, Classify(result.Err), ClassRetryable)
+}
@@ -0,0 +1,263 @@
+package quotafetcher
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GlazedCurd/PlataTest/internal/model"
+	"github.com/GlazedCurd/PlataTest/internal/retry"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+const ecbBaseCurrency = "EUR"
+
+// ecbRecentHistory is served from eurofxref-hist-90d.xml, older days only from
+// the much larger eurofxref-hist.xml. It is shorter than the file's 90 days so
+// that the last business day before asOf is still in the file.
+const ecbRecentHistory = 80 * 24 * time.Hour
+
+// ecbHistoryTTL is how long a parsed history file is reused. The ECB publishes
+// new rates once a business day, so it only delays the newest day of the 90
+// day file.
+const ecbHistoryTTL = time.Hour
+
+// ecbQuotaFetcher quotes any pair via EUR using the European Central Bank
+// daily reference rates. The source is free and keyless.
+type ecbQuotaFetcher struct {
+	httpSource
+	baseUrl string
+	// history keeps the parsed history files, so that the batches of a day
+	// and the legs of a triangulated one share a download.
+	history *ecbHistoryCache
+}
+
+type ecbDay struct {
+	Time  string `xml:"time,attr"`
+	Rates []struct {
+		Currency string          `xml:"currency,attr"`
+		Rate     decimal.Decimal `xml:"rate,attr"`
+	} `xml:"Cube"`
+}
+
+// ecbEnvelope is eurofxref-daily.xml, the history files list more days, newest first:
+//
+//	<gesmes:Envelope>
+//	    <Cube>
+//	        <Cube time="2025-08-15">
+//	            <Cube currency="USD" rate="1.1702"/>
+type ecbEnvelope struct {
+	Cube struct {
+		Days []ecbDay `xml:"Cube"`
+	} `xml:"Cube"`
+}
+
+// ecbRates are the days of a reference rates file indexed by date.
+type ecbRates struct {
+	// dates are ascending, dates[i] is the date of days[i].
+	dates []model.Date
+	days  []ecbDay
+}
+
+func newECBRates(envelope *ecbEnvelope) (*ecbRates, error) {
+	if len(envelope.Cube.Days) == 0 {
+		return nil, fmt.Errorf("no reference rates in response: %w", ErrNonRetryable)
+	}
+	res := &ecbRates{days: envelope.Cube.Days}
+	slices.SortFunc(res.days, func(a, b ecbDay) int {
+		return strings.Compare(a.Time, b.Time)
+	})
+	for _, day := range res.days {
+		date, err := model.ParseDate(day.Time)
+		if err != nil {
+			return nil, fmt.Errorf("parse reference rates date %q: %w: %w", day.Time, err, ErrNonRetryable)
+		}
+		res.dates = append(res.dates, date)
+	}
+	return res, nil
+}
+
+// on returns the last day up to asOf, the latest one for the zero asOf.
+func (r *ecbRates) on(asOf model.Date) (ecbDay, model.Date, bool) {
+	i := len(r.dates)
+	if !asOf.IsZero() {
+		var found bool
+		i, found = slices.BinarySearchFunc(r.dates, asOf, func(date, asOf model.Date) int {
+			return date.Compare(asOf.Time)
+		})
+		if found {
+			i++
+		}
+	}
+	if i == 0 {
+		return ecbDay{}, model.Date{}, false
+	}
+	return r.days[i-1], r.dates[i-1], true
+}
+
+// ecbHistoryCache keeps parsed history files for ttl. Concurrent requests
+// for a file share one download.
+type ecbHistoryCache struct {
+	ttl   time.Duration
+	mu    sync.Mutex
+	files map[string]*ecbHistoryLoad
+}
+
+type ecbHistoryLoad struct {
+	done     chan struct{}
+	rates    *ecbRates
+	err      error
+	loadedAt time.Time
+}
+
+// stale reports whether the load has to be redone. Loads in progress are shared.
+func (l *ecbHistoryLoad) stale(ttl time.Duration) bool {
+	select {
+	case <-l.done:
+		return l.err != nil || time.Since(l.loadedAt) >= ttl
+	default:
+		return false
+	}
+}
+
+// get returns the cached file or loads it. A failed load is returned to the
+// requests sharing it and retried by the next one.
+func (c *ecbHistoryCache) get(ctx context.Context, file string, load func() (*ecbRates, error)) (*ecbRates, error) {
+	c.mu.Lock()
+	entry, ok := c.files[file]
+	if !ok || entry.stale(c.ttl) {
+		entry = &ecbHistoryLoad{done: make(chan struct{})}
+		c.files[file] = entry
+		c.mu.Unlock()
+		entry.rates, entry.err = load()
+		entry.loadedAt = time.Now()
+		close(entry.done)
+		return entry.rates, entry.err
+	}
+	c.mu.Unlock()
+	select {
+	case <-entry.done:
+		return entry.rates, entry.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// NewECBQuotaFetcher creates a fetcher reading eurofxref-daily.xml under baseUrl,
+// e.g. https://www.ecb.europa.eu/stats/eurofxref/, and the history files next
+// to it for historical requests.
+func NewECBQuotaFetcher(httpClient *http.Client, limiter *rate.Limiter, baseUrl string, policy retry.Policy) BatchQuotaFetcher {
+	return &ecbQuotaFetcher{
+		httpSource: newHTTPSource(httpClient, limiter, policy),
+		baseUrl:    baseUrl,
+		history:    &ecbHistoryCache{ttl: ecbHistoryTTL, files: make(map[string]*ecbHistoryLoad)},
+	}
+}
+
+// download reads and parses the reference rates file under baseUrl.
+func (q *ecbQuotaFetcher) download(ctx context.Context, file string, logger *zap.Logger) (*ecbRates, error) {
+	u, err := url.Parse(q.baseUrl)
+	if err != nil {
+		return nil, fmt.Errorf("parse base URL: %w", err)
+	}
+	var envelope ecbEnvelope
+	err = q.get(ctx, u.JoinPath(file), func(resp *http.Response) error {
+		if err := expectOK(resp); err != nil {
+			return err
+		}
+		if err := xml.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+			return fmt.Errorf("decode reference rates: %w: %w", err, ErrNonRetryable)
+		}
+		return nil
+	}, logger)
+	if err != nil {
+		return nil, err
+	}
+	return newECBRates(&envelope)
+}
+
+// fetchEurRates returns the amount of each currency per 1 EUR, EUR included,
+// and the day they are effective on. For a past day asOf that is the last
+// business day up to it.
+func (q *ecbQuotaFetcher) fetchEurRates(ctx context.Context, asOf model.Date, logger *zap.Logger) (map[string]decimal.Decimal, model.Date, error) {
+	var rates *ecbRates
+	var err error
+	if asOf.IsZero() {
+		rates, err = q.download(ctx, "eurofxref-daily.xml", logger)
+	} else {
+		file := "eurofxref-hist.xml"
+		if time.Since(asOf.Time) < ecbRecentHistory {
+			file = "eurofxref-hist-90d.xml"
+		}
+		rates, err = q.history.get(ctx, file, func() (*ecbRates, error) {
+			return q.download(ctx, file, logger)
+		})
+	}
+	if err != nil {
+		return nil, model.Date{}, err
+	}
+
+	day, date, ok := rates.on(asOf)
+	if !ok {
+		return nil, model.Date{}, fmt.Errorf("no reference rates on or before %s: %w", asOf, ErrUnsupportedPair)
+	}
+	eurRates := make(map[string]decimal.Decimal, len(day.Rates)+1)
+	eurRates[ecbBaseCurrency] = decimal.NewFromInt(1)
+	for _, r := range day.Rates {
+		eurRates[r.Currency] = r.Rate
+	}
+	logger.Info("Fetched ECB reference rates", zap.String("date", day.Time), zap.Int("count", len(day.Rates)))
+	return eurRates, date, nil
+}
+
+func (q *ecbQuotaFetcher) FetchQuota(ctx context.Context, code string, logger *zap.Logger) (decimal.Decimal, error) {
+	return fetchOne(ctx, q, code, logger)
+}
+
+func (q *ecbQuotaFetcher) FetchQuotas(ctx context.Context, req BatchRequest, logger *zap.Logger) map[string]Result {
+	eurRates, date, err := q.fetchEurRates(ctx, req.AsOf, logger)
+	if err != nil {
+		return failAll(req, err)
+	}
+	return crossRates(req, eurRates, date)
+}
+
+// pivotPrecision keeps pivot rates derived by division precise enough for the
+// cross rates computed from them.
+const pivotPrecision = 2 * model.PriceScale
+
+// crossRates derives base->target rates from rates quoted against a common
+// pivot currency: pivotRates[c] is the amount of c per one pivot unit.
+// Cross rates are rounded to model.PriceScale and effective on date.
+func crossRates(req BatchRequest, pivotRates map[string]decimal.Decimal, date model.Date) map[string]Result {
+	baseRate, ok := pivotRates[req.Base]
+	if !ok || baseRate.IsZero() {
+		return failAll(req, fmt.Errorf("rate not found for currency: %s: %w", req.Base, ErrUnsupportedPair))
+	}
+	results := make(map[string]Result, len(req.Targets))
+	for _, target := range req.Targets {
+		targetRate, ok := pivotRates[target]
+		if !ok {
+			results[target] = Result{Err: fmt.Errorf("rate not found for currency: %s: %w", target, ErrUnsupportedPair)}
+			continue
+		}
+		results[target] = Result{Price: targetRate.DivRound(baseRate, model.PriceScale), Date: date}
+	}
+	return results
+}
+
+func (q *ecbQuotaFetcher) ListSymbols(ctx context.Context, logger *zap.Logger) (Symbols, error) {
+	eurRates, _, err := q.fetchEurRates(ctx, model.Date{}, logger)
+	if err != nil {
+		return Symbols{}, err
+	}
+	return symbolsOf(eurRates), nil
+}
@@ -0,0 +1,137 @@
+package quotafetcher
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/GlazedCurd/PlataTest/internal/model"
+	"github.com/GlazedCurd/PlataTest/internal/retry"
+	"github.com/go-playground/assert/v2"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+func TestECBFetchQuotas(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer server.Close()
+
+	fetcher := NewECBQuotaFetcher(server.Client(), rate.NewLimiter(rate.Inf, 1), server.URL, retry.Policy{MaxAttempts: 1})
+
+	results := fetcher.FetchQuotas(context.Background(), BatchRequest{Base: "EUR", Targets: []string{"USD", "EUR", "XXX"}}, zap.NewNop())
+	assertPrice(t, results["USD"].Price, "1.1702")
+	assertPrice(t, results["EUR"].Price, "1")
+	assert.Equal(t, Classify(results["XXX"].Err), ClassUnsupportedPair)
+
+	quota, err := fetcher.FetchQuota(context.Background(), "USD_MXN", zap.NewNop())
+	if err != nil {
+		t.Fatalf("fetch quota %s", err)
+	}
+	// 21.84 / 1.1702 rounded to 15 places
+	assertPrice(t, quota, "18.663476328832678")
+}
+
+func TestECBHistoricalRates(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer server.Close()
+
+	fetcher := NewECBQuotaFetcher(server.Client(), rate.NewLimiter(rate.Inf, 1), server.URL, retry.Policy{MaxAttempts: 1})
+
+	tests := []struct {
+		asOf  string
+		price string
+		date  string
+	}{
+		{asOf: "2025-08-14", price: "1.1648", date: "2025-08-14"},
+		// Weekends get the rates of the last business day
+		{asOf: "2025-08-17", price: "1.1702", date: "2025-08-15"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.asOf, func(t *testing.T) {
+			asOf, _ := model.ParseDate(tt.asOf)
+			result := fetcher.FetchQuotas(context.Background(), BatchRequest{Base: "EUR", Targets: []string{"USD"}, AsOf: asOf}, zap.NewNop())["USD"]
+			if result.Err != nil {
+				t.Fatalf("fetch quotas %s", result.Err)
+			}
+			assertPrice(t, result.Price, tt.price)
+			assert.Equal(t, result.Date.String(), tt.date)
+		})
+	}
+
+	asOf, _ := model.ParseDate("2025-08-01")
+	result := fetcher.FetchQuotas(context.Background(), BatchRequest{Base: "EUR", Targets: []string{"USD"}, AsOf: asOf}, zap.NewNop())["USD"]
+	assert.Equal(t, Classify(result.Err), ClassUnsupportedPair)
+}
+
+func TestECBServerError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	fetcher := NewECBQuotaFetcher(server.Client(), rate.NewLimiter(rate.Inf, 1), server.URL, retry.Policy{MaxAttempts: 1})
+
+	_, err := fetcher.FetchQuota(context.Background(), "EUR_USD", zap.NewNop())
+	assert.Equal(t, err != nil, true)
+	assert.Equal(t, Classify(err), ClassRetryable)
+	assert.Equal(t, errors.Is(err, ErrNonRetryable), false)
+}
+
+func TestECBListSymbols(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer server.Close()
+
+	fetcher := NewECBQuotaFetcher(server.Client(), rate.NewLimiter(rate.Inf, 1), server.URL, retry.Policy{MaxAttempts: 1})
+
+	symbols, err := fetcher.(SymbolLister).ListSymbols(context.Background(), zap.NewNop())
+	if err != nil {
+		t.Fatalf("list symbols %s", err)
+	}
+	assert.Equal(t, symbols.Bases, symbols.Targets)
+	assert.Equal(t, slices.Contains(symbols.Targets, "EUR"), true)
+	assert.Equal(t, slices.Contains(symbols.Targets, "USD"), true)
+	assert.Equal(t, slices.IsSorted(symbols.Targets), true)
+}
+
+func TestECBHistoryCache(t *testing.T) {
+	var mu sync.Mutex
+	downloads := make(map[string]int)
+	files := http.FileServer(http.Dir("testdata"))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		downloads[r.URL.Path]++
+		mu.Unlock()
+		files.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	fetcher := NewECBQuotaFetcher(server.Client(), rate.NewLimiter(rate.Inf, 1), server.URL, retry.Policy{MaxAttempts: 1})
+	fetch := func(base, asOf string) Result {
+		day, _ := model.ParseDate(asOf)
+		return fetcher.FetchQuotas(context.Background(), BatchRequest{Base: base, Targets: []string{"USD"}, AsOf: day}, zap.NewNop())["USD"]
+	}
+
+	// Concurrent batches and other days share one download
+	var wg sync.WaitGroup
+	for _, base := range []string{"EUR", "JPY", "USD"} {
+		wg.Go(func() {
+			assert.Equal(t, fetch(base, "2025-08-14").Err, nil)
+		})
+	}
+	wg.Wait()
+	assertPrice(t, fetch("EUR", "2025-08-17").Price, "1.1702")
+	assert.Equal(t, downloads["/eurofxref-hist.xml"], 1)
+
+	// Latest rates are never cached
+	_ = fetcher.FetchQuotas(context.Background(), BatchRequest{Base: "EUR", Targets: []string{"USD"}}, zap.NewNop())
+	_ = fetcher.FetchQuotas(context.Background(), BatchRequest{Base: "EUR", Targets: []string{"USD"}}, zap.NewNop())
+	assert.Equal(t, downloads["/eurofxref-daily.xml"], 2)
+
+	fetcher.(*ecbQuotaFetcher).history.ttl = 0
+	assertPrice(t, fetch("EUR", "2025-08-14").Price, "1.1648")
+	assert.Equal(t, downloads["/eurofxref-hist.xml"], 2)
+}
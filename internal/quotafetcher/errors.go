@@ -0,0 +1,111 @@
+package quotafetcher
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/GlazedCurd/PlataTest/internal/model"
+)
+
+// ErrNonRetryable marks errors that won't go away if the task is retried later,
+// e.g. a malformed pair or a request rejected by the provider.
+var ErrNonRetryable = errors.New("non-retryable")
+
+// ErrRateLimited means the provider answered 429 Too Many Requests. It is
+// retryable: the request succeeds once the provider's window resets.
+var ErrRateLimited = errors.New("rate limited")
+
+// ErrNoConsensus means too few providers agreed on the price. It is retryable.
+var ErrNoConsensus = errors.New("no consensus")
+
+var (
+	// ErrUnsupportedPair means the provider can't quote the pair, e.g. an unknown
+	// symbol or a base currency restricted on our plan.
+	ErrUnsupportedPair = &classifiedError{msg: "unsupported pair", class: ClassUnsupportedPair}
+	// ErrAccessDenied means the provider rejected our credentials or the plan
+	// quota is exhausted.
+	ErrAccessDenied = &classifiedError{msg: "access denied", class: ClassAccessDenied}
+)
+
+// ErrorClass tells how a fetch error should be handled by callers.
+type ErrorClass int
+
+const (
+	// ClassRetryable errors are transient: the same request may succeed later.
+	ClassRetryable ErrorClass = iota
+	// ClassUnsupportedPair errors are permanent for the provider but another one may quote the pair.
+	ClassUnsupportedPair
+	// ClassAccessDenied errors are permanent for the provider until its configuration is fixed.
+	ClassAccessDenied
+	// ClassPermanent errors won't be fixed by retrying with any provider.
+	ClassPermanent
+)
+
+func (c ErrorClass) String() string {
+	switch c {
+	case ClassRetryable:
+		return "retryable"
+	case ClassUnsupportedPair:
+		return "unsupported_pair"
+	case ClassAccessDenied:
+		return "access_denied"
+	default:
+		return "permanent"
+	}
+}
+
+type classifiedError struct {
+	msg   string
+	class ErrorClass
+}
+
+func (e *classifiedError) Error() string {
+	return e.msg
+}
+
+// Unwrap makes every classified error non-retryable at the queue level.
+func (e *classifiedError) Unwrap() error {
+	return ErrNonRetryable
+}
+
+// Classify returns the class of a fetch error.
+func Classify(err error) ErrorClass {
+	var classified *classifiedError
+	if errors.As(err, &classified) {
+		return classified.class
+	}
+	if errors.Is(err, ErrNonRetryable) {
+		return ClassPermanent
+	}
+	return ClassRetryable
+}
+
+// statusError classifies an HTTP 4xx status without a recognisable error body.
+func statusError(statusCode int) error {
+	switch statusCode {
+	case http.StatusUnauthorized, http.StatusPaymentRequired, http.StatusForbidden:
+		return ErrAccessDenied
+	default:
+		return ErrNonRetryable
+	}
+}
+
+// ErrorCode returns the machine-readable code of a fetch error stored with the task.
+func ErrorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrRateLimited):
+		return model.ERROR_RATE_LIMITED
+	case errors.Is(err, ErrNoConsensus):
+		return model.ERROR_NO_CONSENSUS
+	}
+	switch Classify(err) {
+	case ClassUnsupportedPair:
+		return model.ERROR_UNSUPPORTED_PAIR
+	case ClassAccessDenied:
+		return model.ERROR_ACCESS_DENIED
+	case ClassPermanent:
+		return model.ERROR_PROVIDER_ERROR
+	default:
+		return model.ERROR_PROVIDER_UNAVAILABLE
+	}
+}
@@ -0,0 +1,27 @@
+package quotafetcher
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/GlazedCurd/PlataTest/internal/model"
+	"github.com/go-playground/assert/v2"
+)
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		code string
+	}{
+		{err: errors.New("connection refused"), code: model.ERROR_PROVIDER_UNAVAILABLE},
+		{err: fmt.Errorf("server error: 429: %w", ErrRateLimited), code: model.ERROR_RATE_LIMITED},
+		{err: fmt.Errorf("quorum not reached: %w", ErrNoConsensus), code: model.ERROR_NO_CONSENSUS},
+		{err: fmt.Errorf("provider error: %w", ErrUnsupportedPair), code: model.ERROR_UNSUPPORTED_PAIR},
+		{err: fmt.Errorf("provider error: %w", ErrAccessDenied), code: model.ERROR_ACCESS_DENIED},
+		{err: fmt.Errorf("decode response: %w", ErrNonRetryable), code: model.ERROR_PROVIDER_ERROR},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, ErrorCode(tt.err), tt.code)
+	}
+}
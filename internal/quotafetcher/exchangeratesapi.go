@@ -6,118 +6,168 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"slices"
 	"strings"
-	"time"
 
+	"github.com/GlazedCurd/PlataTest/internal/model"
+	"github.com/GlazedCurd/PlataTest/internal/retry"
+	"github.com/shopspring/decimal"
 	"go.uber.org/zap"
 	"golang.org/x/time/rate"
 )
 
+// exchangeratesQuotaFetcher talks to exchangeratesapi.io. Fixer.io shares
+// the same API, so it works by pointing baseUrl there.
 type exchangeratesQuotaFetcher struct {
-	httpClient   *http.Client
-	rateLimiter  *rate.Limiter
-	apiKey       string
-	baseUrl      string
-	retriesLimit int
+	httpSource
+	apiKey  string
+	baseUrl string
+	// bases are the base currencies our plan allows, e.g. only EUR on the free one.
+	bases []string
 }
 
 type exchangeratesResponse struct {
-	Success   bool               `json:"success"`
-	Timestamp int64              `json:"timestamp"`
-	Base      string             `json:"base"`
-	Date      string             `json:"date"`
-	Rates     map[string]float64 `json:"rates"`
+	Success   bool                       `json:"success"`
+	Timestamp int64                      `json:"timestamp"`
+	Base      string                     `json:"base"`
+	Date      string                     `json:"date"`
+	Rates     map[string]decimal.Decimal `json:"rates"`
+	Symbols   map[string]string          `json:"symbols"`
+	Error     *exchangeratesError        `json:"error"`
 }
 
-func NewExchangeratesQuotaFetcher(httpClient *http.Client, limiter *rate.Limiter, apiKey string, baseUrl string, retriesLimit int) QuotaFetcher {
-	return &exchangeratesQuotaFetcher{
-		httpClient:   httpClient,
-		rateLimiter:  limiter,
-		apiKey:       apiKey,
-		baseUrl:      baseUrl,
-		retriesLimit: retriesLimit,
-	}
+// exchangeratesError comes either as {"code": 101, "type": "invalid_access_key"}
+// or as {"code": "base_currency_access_restricted", "message": "..."}.
+type exchangeratesError struct {
+	Code    json.RawMessage `json:"code"`
+	Type    string          `json:"type"`
+	Message string          `json:"message"`
+	Info    string          `json:"info"`
 }
 
-func (q *exchangeratesQuotaFetcher) doRequest(ctx context.Context, url *url.URL, to string, logger *zap.Logger) (float64, bool, error) {
-	if err := q.rateLimiter.Wait(ctx); err != nil {
-		return 0, false, fmt.Errorf("rate limit canceled: %w", err)
+func (e *exchangeratesError) kind() string {
+	if e.Type != "" {
+		return e.Type
 	}
-	resp, err := q.httpClient.Get(url.String())
-	if err != nil {
-		return 0, true, fmt.Errorf("fetch quota: %w", err)
+	var code string
+	if err := json.Unmarshal(e.Code, &code); err == nil {
+		return code
 	}
-	defer func() {
-		err := resp.Body.Close()
-		if err != nil {
-			logger.Error("closing response body", zap.Error(err))
-		}
-	}()
+	return string(e.Code)
+}
 
-	if resp.StatusCode >= 500 {
-		return 0, true, fmt.Errorf("server error: %s", resp.Status)
+func (e *exchangeratesError) toError() error {
+	kind := e.kind()
+	switch kind {
+	case "base_currency_access_restricted", "invalid_base_currency", "invalid_currency_codes":
+		return fmt.Errorf("provider error %s: %w", kind, ErrUnsupportedPair)
+	case "invalid_access_key", "missing_access_key", "inactive_user", "usage_limit_reached",
+		"function_access_restricted", "https_access_restricted":
+		return fmt.Errorf("provider error %s: %w", kind, ErrAccessDenied)
+	default:
+		return fmt.Errorf("provider error %s %s%s: %w", kind, e.Message, e.Info, ErrNonRetryable)
 	}
+}
 
-	if resp.StatusCode >= 400 {
-		return 0, false, fmt.Errorf("client request error: %s", resp.Status)
+func NewExchangeratesQuotaFetcher(httpClient *http.Client, limiter *rate.Limiter, apiKey string, baseUrl string, bases []string, policy retry.Policy) BatchQuotaFetcher {
+	return &exchangeratesQuotaFetcher{
+		httpSource: newHTTPSource(httpClient, limiter, policy),
+		apiKey:     apiKey,
+		baseUrl:    baseUrl,
+		bases:      bases,
 	}
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return 0, false, fmt.Errorf("unexpected response status: %s", resp.Status)
+// decode reads the response into rates. Failures carry an error object, and
+// a body without one must still report success.
+func (r *exchangeratesResponse) decode(resp *http.Response) error {
+	if err := decodeErrorBody(resp, r); err != nil {
+		return err
 	}
 
-	var response exchangeratesResponse
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return 0, false, fmt.Errorf("decode response: %w", err)
+	if r.Error != nil {
+		return r.Error.toError()
 	}
 
-	if !response.Success {
-		return 0, false, fmt.Errorf("API request was not successful")
+	if err := expectOK(resp); err != nil {
+		return err
 	}
 
-	rate, ok := response.Rates[to]
-	if !ok {
-		return 0, false, fmt.Errorf("rate not found for currency: %s", to)
+	if !r.Success {
+		return fmt.Errorf("API request was not successful: %w", ErrNonRetryable)
 	}
-	return rate, false, nil
+	return nil
+}
+
+func (q *exchangeratesQuotaFetcher) FetchQuota(ctx context.Context, code string, logger *zap.Logger) (decimal.Decimal, error) {
+	return fetchOne(ctx, q, code, logger)
 }
 
-func (q *exchangeratesQuotaFetcher) FetchQuota(ctx context.Context, code string, logger *zap.Logger) (float64, error) {
+func (q *exchangeratesQuotaFetcher) FetchQuotas(ctx context.Context, req BatchRequest, logger *zap.Logger) map[string]Result {
+	// The API would reject the base as well, but only after spending a request of the quota
+	if !slices.Contains(q.bases, req.Base) {
+		return failAll(req, fmt.Errorf("base %s is not allowed by the plan: %w", req.Base, ErrUnsupportedPair))
+	}
+
 	u, err := url.Parse(q.baseUrl)
 	if err != nil {
-		return 0, fmt.Errorf("parse base URL: %w", err)
+		return failAll(req, fmt.Errorf("parse base URL: %w", err))
 	}
 
 	u.Path = "v1/latest"
-
-	parts := strings.Split(code, "_")
-	if len(parts) != 2 {
-		return 0, fmt.Errorf("invalid code format: %s", code)
+	if !req.AsOf.IsZero() {
+		u.Path = "v1/" + req.AsOf.String()
 	}
-	from := parts[0]
-	to := parts[1]
 
 	query := u.Query()
 	query.Set("access_key", q.apiKey)
-	query.Set("base", from)
-	query.Set("symbols", to)
+	query.Set("base", req.Base)
+	query.Set("symbols", strings.Join(req.Targets, ","))
 	u.RawQuery = query.Encode()
-	currTimeout := 1
-	var lastError error
-	for i := 0; i < q.retriesLimit; i++ {
-		quota, retry, err := q.doRequest(ctx, u, to, logger)
-		if err != nil {
-			logger.Error("fetch quota", zap.Error(err), zap.Int("retry", i))
-			lastError = err
-			if !retry {
-				break
-			}
-			time.Sleep(time.Duration(currTimeout) * time.Second)
-			currTimeout *= 2
+	var response exchangeratesResponse
+	if err := q.get(ctx, u, response.decode, logger); err != nil {
+		return failAll(req, err)
+	}
+	// The date tells which day's rates historical requests for weekends and holidays fall back to
+	var date model.Date
+	if response.Date != "" {
+		if date, err = model.ParseDate(response.Date); err != nil {
+			logger.Warn("Unparsable rates date", zap.String("date", response.Date), zap.Error(err))
+		}
+	}
+
+	results := make(map[string]Result, len(req.Targets))
+	for _, target := range req.Targets {
+		rate, ok := response.Rates[target]
+		if !ok {
+			results[target] = Result{Err: fmt.Errorf("rate not found for currency: %s: %w", target, ErrUnsupportedPair)}
 			continue
 		}
-		return quota, nil
+		results[target] = Result{Price: rate, Date: date}
 	}
+	return results
+}
 
-	return 0, fmt.Errorf("fetch quota after retiries %w", lastError)
+// ListSymbols reads v1/symbols. The API doesn't tell which symbols the plan
+// allows as the base, so only the configured bases are listed as such.
+func (q *exchangeratesQuotaFetcher) ListSymbols(ctx context.Context, logger *zap.Logger) (Symbols, error) {
+	u, err := url.Parse(q.baseUrl)
+	if err != nil {
+		return Symbols{}, fmt.Errorf("parse base URL: %w", err)
+	}
+
+	u.Path = "v1/symbols"
+
+	query := u.Query()
+	query.Set("access_key", q.apiKey)
+	u.RawQuery = query.Encode()
+	var response exchangeratesResponse
+	if err := q.get(ctx, u, response.decode, logger); err != nil {
+		return Symbols{}, err
+	}
+	symbols := symbolsOf(response.Symbols)
+	symbols.Bases = slices.DeleteFunc(slices.Clone(symbols.Targets), func(code string) bool {
+		return !slices.Contains(q.bases, code)
+	})
+	return symbols, nil
 }
@@ -0,0 +1,52 @@
+package quotafetcher
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/GlazedCurd/PlataTest/internal/retry"
+	"github.com/go-playground/assert/v2"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+func TestExchangeratesListSymbols(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.URL.Path, "/v1/symbols")
+		assert.Equal(t, r.URL.Query().Get("access_key"), "secret")
+		_, _ = w.Write([]byte(`{"success": true, "symbols": {"USD": "United States Dollar", "EUR": "Euro", "GBP": "British Pound Sterling"}}`))
+	}))
+	defer server.Close()
+
+	fetcher := NewExchangeratesQuotaFetcher(server.Client(), rate.NewLimiter(rate.Inf, 1), "secret", server.URL, []string{"EUR", "CHF"}, retry.Policy{MaxAttempts: 1})
+
+	symbols, err := fetcher.(SymbolLister).ListSymbols(context.Background(), zap.NewNop())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, symbols, Symbols{Bases: []string{"EUR"}, Targets: []string{"EUR", "GBP", "USD"}})
+}
+
+func TestExchangeratesUnsupportedBase(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, r.URL.Query().Get("base"), "EUR")
+		_, _ = w.Write([]byte(`{"success": true, "base": "EUR", "date": "2025-08-17", "rates": {"USD": 1.17}}`))
+	}))
+	defer server.Close()
+
+	fetcher := NewExchangeratesQuotaFetcher(server.Client(), rate.NewLimiter(rate.Inf, 1), "secret", server.URL, []string{"EUR"}, retry.Policy{MaxAttempts: 1})
+
+	results := fetcher.FetchQuotas(context.Background(), BatchRequest{Base: "USD", Targets: []string{"EUR", "GBP"}}, zap.NewNop())
+	assert.Equal(t, requests, 0)
+	assert.Equal(t, len(results), 2)
+	for _, result := range results {
+		assert.Equal(t, errors.Is(result.Err, ErrUnsupportedPair), true)
+	}
+
+	results = fetcher.FetchQuotas(context.Background(), BatchRequest{Base: "EUR", Targets: []string{"USD"}}, zap.NewNop())
+	assert.Equal(t, requests, 1)
+	assertPrice(t, results["USD"].Price, "1.17")
+}
@@ -0,0 +1,90 @@
+package quotafetcher
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+)
+
+// Provider is a named quote source.
+type Provider struct {
+	Name    string
+	Fetcher BatchQuotaFetcher
+}
+
+type failoverQuotaFetcher struct {
+	providers []Provider
+}
+
+// NewFailoverQuotaFetcher asks the providers in order and passes the targets
+// a provider failed on to the next one. Results record the provider that answered.
+func NewFailoverQuotaFetcher(providers []Provider) BatchQuotaFetcher {
+	return &failoverQuotaFetcher{providers: providers}
+}
+
+type providerError struct {
+	provider string
+	err      error
+}
+
+func (q *failoverQuotaFetcher) FetchQuota(ctx context.Context, code string, logger *zap.Logger) (decimal.Decimal, error) {
+	return fetchOne(ctx, q, code, logger)
+}
+
+func (q *failoverQuotaFetcher) FetchQuotas(ctx context.Context, req BatchRequest, logger *zap.Logger) map[string]Result {
+	results := make(map[string]Result, len(req.Targets))
+	failures := make(map[string][]providerError)
+	remaining := req.Targets
+	for _, provider := range q.providers {
+		if len(remaining) == 0 || ctx.Err() != nil {
+			break
+		}
+		providerLogger := logger.With(zap.String("provider", provider.Name))
+		providerResults := provider.Fetcher.FetchQuotas(ctx, BatchRequest{Base: req.Base, Targets: remaining, AsOf: req.AsOf}, providerLogger)
+		var failed []string
+		for _, target := range remaining {
+			result, ok := providerResults[target]
+			if !ok {
+				result.Err = fmt.Errorf("no result for currency: %s", target)
+			}
+			if result.Err != nil {
+				providerLogger.Warn("Provider failed, falling through",
+					zap.String("target", target),
+					zap.String("class", Classify(result.Err).String()),
+					zap.Error(result.Err))
+				failures[target] = append(failures[target], providerError{provider: provider.Name, err: result.Err})
+				failed = append(failed, target)
+				continue
+			}
+			result.Provider = provider.Name
+			results[target] = result
+		}
+		remaining = failed
+	}
+
+	for _, target := range remaining {
+		results[target] = Result{Err: chainError(ctx, failures[target])}
+	}
+	return results
+}
+
+// chainError summarises the failures of every provider. The task is judged by
+// the first retryable failure, if any: a provider that is down now may answer
+// later, while "unsupported pair" from everyone is final.
+func chainError(ctx context.Context, failures []providerError) error {
+	if len(failures) == 0 {
+		return fmt.Errorf("no provider tried: %w", ctx.Err())
+	}
+	representative := failures[0]
+	var messages []string
+	for _, failure := range failures {
+		messages = append(messages, fmt.Sprintf("%s: %s", failure.provider, failure.err))
+		if Classify(representative.err) != ClassRetryable && Classify(failure.err) == ClassRetryable {
+			representative = failure
+		}
+	}
+	return fmt.Errorf("all providers failed [%s]: %w", strings.Join(messages, "; "), representative.err)
+}
@@ -0,0 +1,78 @@
+package quotafetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+)
+
+type batchMock struct {
+	singlePairMock
+	fetchQuotas func(ctx context.Context, req BatchRequest, logger *zap.Logger) map[string]Result
+}
+
+func (m *batchMock) FetchQuotas(ctx context.Context, req BatchRequest, logger *zap.Logger) map[string]Result {
+	return m.fetchQuotas(ctx, req, logger)
+}
+
+func staticProvider(name string, rates map[string]float64, errs map[string]error) Provider {
+	return Provider{Name: name, Fetcher: &batchMock{
+		fetchQuotas: func(ctx context.Context, req BatchRequest, logger *zap.Logger) map[string]Result {
+			results := make(map[string]Result)
+			for _, target := range req.Targets {
+				if err, ok := errs[target]; ok {
+					results[target] = Result{Err: err}
+					continue
+				}
+				results[target] = Result{Price: decimal.NewFromFloat(rates[target])}
+			}
+			return results
+		},
+	}}
+}
+
+func TestFailoverFallsThrough(t *testing.T) {
+	fetcher := NewFailoverQuotaFetcher([]Provider{
+		staticProvider("first", map[string]float64{"USD": 1.1}, map[string]error{
+			"MXN": fmt.Errorf("restricted: %w", ErrUnsupportedPair),
+		}),
+		staticProvider("second", map[string]float64{"MXN": 20.5}, nil),
+	})
+
+	results := fetcher.FetchQuotas(context.Background(), BatchRequest{Base: "EUR", Targets: []string{"USD", "MXN"}}, zap.NewNop())
+
+	assertPrice(t, results["USD"].Price, "1.1")
+	assert.Equal(t, results["USD"].Provider, "first")
+	assertPrice(t, results["MXN"].Price, "20.5")
+	assert.Equal(t, results["MXN"].Provider, "second")
+}
+
+func TestFailoverPrefersRetryableError(t *testing.T) {
+	errDown := errors.New("server error: 503 Service Unavailable")
+	fetcher := NewFailoverQuotaFetcher([]Provider{
+		staticProvider("first", nil, map[string]error{"MXN": errDown}),
+		staticProvider("second", nil, map[string]error{"MXN": fmt.Errorf("unknown symbol: %w", ErrUnsupportedPair)}),
+	})
+
+	result := fetcher.FetchQuotas(context.Background(), BatchRequest{Base: "EUR", Targets: []string{"MXN"}}, zap.NewNop())["MXN"]
+
+	assert.Equal(t, errors.Is(result.Err, errDown), true)
+	assert.Equal(t, Classify(result.Err), ClassRetryable)
+}
+
+func TestFailoverAllUnsupported(t *testing.T) {
+	fetcher := NewFailoverQuotaFetcher([]Provider{
+		staticProvider("first", nil, map[string]error{"MXN": fmt.Errorf("restricted: %w", ErrUnsupportedPair)}),
+		staticProvider("second", nil, map[string]error{"MXN": fmt.Errorf("bad key: %w", ErrAccessDenied)}),
+	})
+
+	result := fetcher.FetchQuotas(context.Background(), BatchRequest{Base: "USD", Targets: []string{"MXN"}}, zap.NewNop())["MXN"]
+
+	assert.Equal(t, Classify(result.Err), ClassUnsupportedPair)
+	assert.Equal(t, errors.Is(result.Err, ErrNonRetryable), true)
+}
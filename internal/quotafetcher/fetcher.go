@@ -2,10 +2,126 @@ package quotafetcher
 
 import (
 	"context"
+	"fmt"
+	"sort"
+	"strings"
 
+	"github.com/GlazedCurd/PlataTest/internal/model"
+	"github.com/shopspring/decimal"
 	"go.uber.org/zap"
 )
 
 type QuotaFetcher interface {
-	FetchQuota(ctx context.Context, code string, logger *zap.Logger) (float64, error)
+	FetchQuota(ctx context.Context, code string, logger *zap.Logger) (decimal.Decimal, error)
+}
+
+// BatchRequest asks for the rates from Base to each of Targets.
+type BatchRequest struct {
+	Base    string
+	Targets []string
+	// AsOf asks for the rates of a past day, the zero value for the latest ones.
+	AsOf model.Date
+}
+
+// Result is the outcome of a batch request for a single target.
+type Result struct {
+	Price decimal.Decimal
+	// Date is the day the rate is effective on, zero if the provider doesn't tell.
+	Date model.Date
+	// Provider is the name of the provider that answered, set by NewFailoverQuotaFetcher.
+	Provider string
+	// Path lists the currencies a triangulated rate was derived through, both ends included.
+	Path []string
+	// Contributions are the per-provider rates a consensus price was derived from.
+	Contributions []model.Contribution
+	Err           error
+}
+
+// BatchQuotaFetcher is implemented by providers able to quote many targets
+// against one base in a single call.
+type BatchQuotaFetcher interface {
+	QuotaFetcher
+	// FetchQuotas returns a result for every requested target. Errors are
+	// reported per target, so one unsupported symbol doesn't fail the others.
+	FetchQuotas(ctx context.Context, req BatchRequest, logger *zap.Logger) map[string]Result
+}
+
+// Symbols lists the currencies a provider quotes.
+type Symbols struct {
+	// Bases can be requested as BatchRequest.Base.
+	Bases []string
+	// Targets can be requested in BatchRequest.Targets.
+	Targets []string
+}
+
+// SymbolLister is implemented by providers able to tell which currencies they quote.
+type SymbolLister interface {
+	ListSymbols(ctx context.Context, logger *zap.Logger) (Symbols, error)
+}
+
+// symbolsOf lists the keys of codes both as bases and targets, e.g. for
+// providers crossing every pair through a pivot currency.
+func symbolsOf[V any](codes map[string]V) Symbols {
+	res := make([]string, 0, len(codes))
+	for code := range codes {
+		res = append(res, code)
+	}
+	sort.Strings(res)
+	return Symbols{Bases: res, Targets: res}
+}
+
+// AsBatch lets a single-pair provider serve batch requests by fetching the
+// targets one by one. Batch-capable fetchers are returned as is.
+func AsBatch(f QuotaFetcher) BatchQuotaFetcher {
+	if batch, ok := f.(BatchQuotaFetcher); ok {
+		return batch
+	}
+	return &singlePairAdapter{QuotaFetcher: f}
+}
+
+type singlePairAdapter struct {
+	QuotaFetcher
+}
+
+func (a *singlePairAdapter) FetchQuotas(ctx context.Context, req BatchRequest, logger *zap.Logger) map[string]Result {
+	if !req.AsOf.IsZero() {
+		return failAll(req, fmt.Errorf("historical rates not supported: %w", ErrUnsupportedPair))
+	}
+	results := make(map[string]Result, len(req.Targets))
+	for _, target := range req.Targets {
+		price, err := a.FetchQuota(ctx, req.Base+"_"+target, logger)
+		results[target] = Result{Price: price, Err: err}
+	}
+	return results
+}
+
+// failAll reports the same error for every target of the request.
+func failAll(req BatchRequest, err error) map[string]Result {
+	results := make(map[string]Result, len(req.Targets))
+	for _, target := range req.Targets {
+		results[target] = Result{Err: err}
+	}
+	return results
+}
+
+// earliestDate returns the earliest of the known dates, a rate derived from
+// several others is no fresher than the oldest of them.
+func earliestDate(dates ...model.Date) model.Date {
+	var res model.Date
+	for _, date := range dates {
+		if !date.IsZero() && (res.IsZero() || date.Before(res.Time)) {
+			res = date
+		}
+	}
+	return res
+}
+
+// fetchOne serves FetchQuota of a batch fetcher with a single-target batch.
+func fetchOne(ctx context.Context, fetcher BatchQuotaFetcher, code string, logger *zap.Logger) (decimal.Decimal, error) {
+	parts := strings.Split(code, "_")
+	if len(parts) != 2 {
+		return decimal.Decimal{}, fmt.Errorf("invalid code format: %s: %w", code, ErrNonRetryable)
+	}
+	result := fetcher.FetchQuotas(ctx, BatchRequest{Base: parts[0], Targets: []string{parts[1]}}, logger)[parts[1]]
+	return result.Price, result.Err
 }
@@ -0,0 +1,53 @@
+package quotafetcher
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/GlazedCurd/PlataTest/internal/retry"
+	"github.com/go-playground/assert/v2"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+)
+
+type singlePairMock struct {
+	fetchQuota func(ctx context.Context, code string, logger *zap.Logger) (decimal.Decimal, error)
+}
+
+func (m *singlePairMock) FetchQuota(ctx context.Context, code string, logger *zap.Logger) (decimal.Decimal, error) {
+	return m.fetchQuota(ctx, code, logger)
+}
+
+func TestAsBatchAdaptsSinglePairFetcher(t *testing.T) {
+	errUnsupported := errors.New("unsupported")
+	var requested []string
+	fetcher := AsBatch(&singlePairMock{
+		fetchQuota: func(ctx context.Context, code string, logger *zap.Logger) (decimal.Decimal, error) {
+			requested = append(requested, code)
+			if code == "EUR_XXX" {
+				return decimal.Decimal{}, errUnsupported
+			}
+			return decimal.RequireFromString("1.5"), nil
+		},
+	})
+
+	results := fetcher.FetchQuotas(context.Background(), BatchRequest{Base: "EUR", Targets: []string{"USD", "XXX"}}, zap.NewNop())
+
+	assert.Equal(t, requested, []string{"EUR_USD", "EUR_XXX"})
+	assertPrice(t, results["USD"].Price, "1.5")
+	assert.Equal(t, errors.Is(results["XXX"].Err, errUnsupported), true)
+}
+
+// assertPrice compares prices by value, whatever their decimal exponent.
+func assertPrice(t *testing.T, got decimal.Decimal, want string) {
+	t.Helper()
+	if !got.Equal(decimal.RequireFromString(want)) {
+		t.Errorf("price %s, want %s", got, want)
+	}
+}
+
+func TestAsBatchKeepsBatchFetcher(t *testing.T) {
+	fetcher := NewExchangeratesQuotaFetcher(nil, nil, "key", "http://localhost", []string{"EUR"}, retry.Policy{MaxAttempts: 1})
+	assert.Equal(t, AsBatch(fetcher), fetcher)
+}
@@ -0,0 +1,164 @@
+package quotafetcher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/GlazedCurd/PlataTest/internal/retry"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// httpSource is the HTTP plumbing shared by providers: rate limiting,
+// in-process retries of transient failures and response body handling.
+type httpSource struct {
+	httpClient  *http.Client
+	rateLimiter *rate.Limiter
+	retry       retry.Policy
+}
+
+func newHTTPSource(httpClient *http.Client, limiter *rate.Limiter, policy retry.Policy) httpSource {
+	return httpSource{
+		httpClient:  httpClient,
+		rateLimiter: limiter,
+		retry:       policy,
+	}
+}
+
+// decodeFunc reads a non-5xx response. It owns status handling, since some
+// providers explain 4xx errors in the body. Errors it returns are not retried.
+type decodeFunc func(resp *http.Response) error
+
+// maxThrottle bounds how long a single Retry-After may hold back the limiter.
+const maxThrottle = 10 * time.Minute
+
+// doRequest makes a single request. Errors not worth retrying in-process are
+// marked with retry.Permanent.
+func (s *httpSource) doRequest(ctx context.Context, url *url.URL, decode decodeFunc, logger *zap.Logger) error {
+	if err := s.rateLimiter.Wait(ctx); err != nil {
+		return retry.Permanent(fmt.Errorf("rate limit canceled: %w", err))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url.String(), nil)
+	if err != nil {
+		return retry.Permanent(fmt.Errorf("create request: %w", err))
+	}
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetch quota: %w", err)
+	}
+	defer func() {
+		err := resp.Body.Close()
+		if err != nil {
+			logger.Error("closing response body", zap.Error(err))
+		}
+	}()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		err := fmt.Errorf("server error: %s: %w", resp.Status, ErrRateLimited)
+		return withRetryAfter(err, resp.Header.Get("Retry-After"), time.Now())
+	}
+	if resp.StatusCode >= 500 {
+		err := fmt.Errorf("server error: %s", resp.Status)
+		return withRetryAfter(err, resp.Header.Get("Retry-After"), time.Now())
+	}
+
+	if err := decode(resp); err != nil {
+		return retry.Permanent(err)
+	}
+	return nil
+}
+
+// withRetryAfter attaches the Retry-After hint, given either in seconds or as
+// an HTTP date, to err. Missing or malformed headers leave err as is.
+func withRetryAfter(err error, header string, now time.Time) error {
+	if header == "" {
+		return err
+	}
+	if secs, parseErr := strconv.Atoi(header); parseErr == nil {
+		return retry.After(err, time.Duration(secs)*time.Second)
+	}
+	if date, parseErr := http.ParseTime(header); parseErr == nil {
+		return retry.After(err, date.Sub(now))
+	}
+	return err
+}
+
+// get requests url and hands the response to decode, retrying transient
+// failures according to the source's retry policy. A Retry-After given by the
+// server takes precedence over the policy's delay, and rate-limit responses
+// slow down every request sharing the limiter.
+func (s *httpSource) get(ctx context.Context, url *url.URL, decode decodeFunc, logger *zap.Logger) error {
+	err := s.retry.Do(ctx, func(ctx context.Context, attempt int) error {
+		err := s.doRequest(ctx, url, decode, logger)
+		if err == nil {
+			return nil
+		}
+		logger.Error("fetch quota", zap.Error(err), zap.Int("attempt", attempt))
+		if after, ok := retry.AfterHint(err); ok {
+			s.throttle(after, time.Now())
+		} else if errors.Is(err, ErrRateLimited) {
+			s.throttle(s.retry.InitialDelay, time.Now())
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("fetch quota: %w", err)
+	}
+	return nil
+}
+
+// throttle holds the shared limiter back for d, so every goroutine going to
+// the provider waits out the server's rate limit instead of hitting it again.
+// It reserves tokens until the next one is available no earlier than d from now.
+func (s *httpSource) throttle(d time.Duration, now time.Time) {
+	d = min(d, maxThrottle)
+	limit := s.rateLimiter.Limit()
+	if limit == rate.Inf || limit <= 0 || d <= 0 {
+		return
+	}
+	burst := max(s.rateLimiter.Burst(), 1)
+	for {
+		probe := s.rateLimiter.ReserveN(now, 1)
+		if !probe.OK() {
+			return
+		}
+		missing := d - probe.DelayFrom(now)
+		probe.CancelAt(now)
+		if missing <= 0 {
+			return
+		}
+		tokens := min(max(int(missing.Seconds()*float64(limit)), 1), burst)
+		if !s.rateLimiter.ReserveN(now, tokens).OK() {
+			return
+		}
+	}
+}
+
+// expectOK rejects responses of providers that don't explain errors in the body.
+func expectOK(resp *http.Response) error {
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("client request error: %s: %w", resp.Status, statusError(resp.StatusCode))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response status: %s: %w", resp.Status, ErrNonRetryable)
+	}
+	return nil
+}
+
+// decodeErrorBody reads the JSON body of providers that explain errors in it,
+// even for 4xx statuses. A 4xx body that doesn't parse is reported by its status.
+func decodeErrorBody(resp *http.Response, v any) error {
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		if resp.StatusCode >= 400 {
+			return fmt.Errorf("client request error: %s: %w", resp.Status, statusError(resp.StatusCode))
+		}
+		return fmt.Errorf("decode response: %w: %w", err, ErrNonRetryable)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package quotafetcher
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/GlazedCurd/PlataTest/internal/retry"
+	"github.com/go-playground/assert/v2"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+func TestHTTPSourceRetriesRateLimited(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	source := newHTTPSource(server.Client(), rate.NewLimiter(rate.Inf, 1), retry.Policy{MaxAttempts: 2})
+	u, _ := url.Parse(server.URL)
+
+	err := source.get(context.Background(), u, expectOK, zap.NewNop())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, calls, 2)
+}
+
+func TestHTTPSourceRateLimitedIsRetryable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	source := newHTTPSource(server.Client(), rate.NewLimiter(rate.Inf, 1), retry.Policy{MaxAttempts: 3})
+	u, _ := url.Parse(server.URL)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// The hint is longer than the context allows, so get gives up at once
+	// instead of sleeping until the deadline.
+	start := time.Now()
+	err := source.get(ctx, u, expectOK, zap.NewNop())
+	assert.Equal(t, errors.Is(err, ErrRateLimited), true)
+	assert.Equal(t, Classify(err), ClassRetryable)
+	assert.Equal(t, time.Since(start) < 500*time.Millisecond, true)
+}
+
+func TestWithRetryAfter(t *testing.T) {
+	now := time.Date(2025, 8, 15, 12, 0, 0, 0, time.UTC)
+	cause := errors.New("server error")
+
+	tests := []struct {
+		header string
+		after  time.Duration
+		hinted bool
+	}{
+		{header: "", hinted: false},
+		{header: "garbage", hinted: false},
+		{header: "120", after: 2 * time.Minute, hinted: true},
+		{header: "Fri, 15 Aug 2025 12:00:30 GMT", after: 30 * time.Second, hinted: true},
+		{header: "Fri, 15 Aug 2025 11:00:00 GMT", after: 0, hinted: true},
+	}
+	for _, tt := range tests {
+		err := withRetryAfter(cause, tt.header, now)
+		after, hinted := retry.AfterHint(err)
+		assert.Equal(t, hinted, tt.hinted)
+		assert.Equal(t, after, tt.after)
+		assert.Equal(t, errors.Is(err, cause), true)
+	}
+}
+
+func TestThrottleHoldsBackLimiter(t *testing.T) {
+	limiter := rate.NewLimiter(rate.Every(time.Second), 1)
+	source := newHTTPSource(http.DefaultClient, limiter, retry.Policy{MaxAttempts: 1})
+	now := time.Now()
+
+	source.throttle(5*time.Second, now)
+
+	reservation := limiter.ReserveN(now, 1)
+	assert.Equal(t, reservation.DelayFrom(now) >= 5*time.Second, true)
+	assert.Equal(t, reservation.DelayFrom(now) < 7*time.Second, true)
+}
@@ -0,0 +1,131 @@
+package quotafetcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/GlazedCurd/PlataTest/internal/model"
+	"github.com/GlazedCurd/PlataTest/internal/retry"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+const openexchangeratesBaseCurrency = "USD"
+
+// openexchangeratesQuotaFetcher talks to openexchangerates.org. Changing the
+// base needs a paid plan, so rates are always requested against USD and other
+// pairs are derived from them.
+type openexchangeratesQuotaFetcher struct {
+	httpSource
+	appId   string
+	baseUrl string
+}
+
+type openexchangeratesResponse struct {
+	Timestamp int64                      `json:"timestamp"`
+	Base      string                     `json:"base"`
+	Rates     map[string]decimal.Decimal `json:"rates"`
+	// Error responses look like {"error": true, "status": 401, "message": "invalid_app_id"}
+	Error       bool   `json:"error"`
+	Message     string `json:"message"`
+	Description string `json:"description"`
+}
+
+func NewOpenexchangeratesQuotaFetcher(httpClient *http.Client, limiter *rate.Limiter, appId string, baseUrl string, policy retry.Policy) BatchQuotaFetcher {
+	return &openexchangeratesQuotaFetcher{
+		httpSource: newHTTPSource(httpClient, limiter, policy),
+		appId:      appId,
+		baseUrl:    baseUrl,
+	}
+}
+
+func (r *openexchangeratesResponse) toError() error {
+	switch r.Message {
+	case "invalid_base", "not_found":
+		return fmt.Errorf("provider error %s: %w", r.Message, ErrUnsupportedPair)
+	case "missing_app_id", "invalid_app_id", "not_allowed", "access_restricted":
+		return fmt.Errorf("provider error %s: %w", r.Message, ErrAccessDenied)
+	default:
+		return fmt.Errorf("provider error %s %s: %w", r.Message, r.Description, ErrNonRetryable)
+	}
+}
+
+// decode reads the response into rates. Failures come as {"error": true,
+// "message": ...}, successful bodies carry no flag of their own.
+func (r *openexchangeratesResponse) decode(resp *http.Response) error {
+	if err := decodeErrorBody(resp, r); err != nil {
+		return err
+	}
+
+	if r.Error {
+		return r.toError()
+	}
+
+	return expectOK(resp)
+}
+
+func (q *openexchangeratesQuotaFetcher) FetchQuota(ctx context.Context, code string, logger *zap.Logger) (decimal.Decimal, error) {
+	return fetchOne(ctx, q, code, logger)
+}
+
+func (q *openexchangeratesQuotaFetcher) FetchQuotas(ctx context.Context, req BatchRequest, logger *zap.Logger) map[string]Result {
+	u, err := url.Parse(q.baseUrl)
+	if err != nil {
+		return failAll(req, fmt.Errorf("parse base URL: %w", err))
+	}
+	path := "latest.json"
+	if !req.AsOf.IsZero() {
+		path = "historical/" + req.AsOf.String() + ".json"
+	}
+	u = u.JoinPath(path)
+
+	query := u.Query()
+	query.Set("app_id", q.appId)
+	query.Set("symbols", strings.Join(append([]string{req.Base}, req.Targets...), ","))
+	u.RawQuery = query.Encode()
+
+	var response openexchangeratesResponse
+	if err := q.get(ctx, u, response.decode, logger); err != nil {
+		return failAll(req, err)
+	}
+
+	if response.Rates == nil {
+		response.Rates = make(map[string]decimal.Decimal)
+	}
+	response.Rates[openexchangeratesBaseCurrency] = decimal.NewFromInt(1)
+	var date model.Date
+	if response.Timestamp != 0 {
+		date = model.NewDate(time.Unix(response.Timestamp, 0))
+	}
+	return crossRates(req, response.Rates, date)
+}
+
+// ListSymbols reads currencies.json, which needs no app id.
+func (q *openexchangeratesQuotaFetcher) ListSymbols(ctx context.Context, logger *zap.Logger) (Symbols, error) {
+	u, err := url.Parse(q.baseUrl)
+	if err != nil {
+		return Symbols{}, fmt.Errorf("parse base URL: %w", err)
+	}
+	u = u.JoinPath("currencies.json")
+
+	var names map[string]string
+	err = q.get(ctx, u, func(resp *http.Response) error {
+		if err := expectOK(resp); err != nil {
+			return err
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&names); err != nil {
+			return fmt.Errorf("decode currencies: %w: %w", err, ErrNonRetryable)
+		}
+		return nil
+	}, logger)
+	if err != nil {
+		return Symbols{}, err
+	}
+	return symbolsOf(names), nil
+}
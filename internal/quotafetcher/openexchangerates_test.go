@@ -0,0 +1,72 @@
+package quotafetcher
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/GlazedCurd/PlataTest/internal/model"
+	"github.com/GlazedCurd/PlataTest/internal/retry"
+	"github.com/go-playground/assert/v2"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+func TestOpenexchangeratesFetchQuotas(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.URL.Path, "/api/latest.json")
+		assert.Equal(t, r.URL.Query().Get("app_id"), "secret")
+		assert.Equal(t, r.URL.Query().Get("symbols"), "EUR,USD,GBP")
+		_, _ = w.Write([]byte(`{"timestamp": 1755280800, "base": "USD", "rates": {"EUR": 0.8, "GBP": 0.75}}`))
+	}))
+	defer server.Close()
+
+	fetcher := NewOpenexchangeratesQuotaFetcher(server.Client(), rate.NewLimiter(rate.Inf, 1), "secret", server.URL+"/api/", retry.Policy{MaxAttempts: 1})
+
+	results := fetcher.FetchQuotas(context.Background(), BatchRequest{Base: "EUR", Targets: []string{"USD", "GBP"}}, zap.NewNop())
+	assertPrice(t, results["USD"].Price, "1.25")
+	assertPrice(t, results["GBP"].Price, "0.9375")
+}
+
+func TestOpenexchangeratesHistoricalRates(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.URL.Path, "/api/historical/2025-08-15.json")
+		_, _ = w.Write([]byte(`{"timestamp": 1755280800, "base": "USD", "rates": {"EUR": 0.8}}`))
+	}))
+	defer server.Close()
+
+	fetcher := NewOpenexchangeratesQuotaFetcher(server.Client(), rate.NewLimiter(rate.Inf, 1), "secret", server.URL+"/api/", retry.Policy{MaxAttempts: 1})
+
+	asOf, _ := model.ParseDate("2025-08-15")
+	result := fetcher.FetchQuotas(context.Background(), BatchRequest{Base: "EUR", Targets: []string{"USD"}, AsOf: asOf}, zap.NewNop())["USD"]
+	assertPrice(t, result.Price, "1.25")
+	assert.Equal(t, result.Date, asOf)
+}
+
+func TestOpenexchangeratesInvalidAppId(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"error": true, "status": 401, "message": "invalid_app_id", "description": "Invalid App ID provided."}`))
+	}))
+	defer server.Close()
+
+	fetcher := NewOpenexchangeratesQuotaFetcher(server.Client(), rate.NewLimiter(rate.Inf, 1), "wrong", server.URL, retry.Policy{MaxAttempts: 3})
+
+	_, err := fetcher.FetchQuota(context.Background(), "USD_EUR", zap.NewNop())
+	assert.Equal(t, Classify(err), ClassAccessDenied)
+}
+
+func TestOpenexchangeratesListSymbols(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.URL.Path, "/api/currencies.json")
+		_, _ = w.Write([]byte(`{"USD": "United States Dollar", "EUR": "Euro", "BTC": "Bitcoin"}`))
+	}))
+	defer server.Close()
+
+	fetcher := NewOpenexchangeratesQuotaFetcher(server.Client(), rate.NewLimiter(rate.Inf, 1), "secret", server.URL+"/api/", retry.Policy{MaxAttempts: 1})
+
+	symbols, err := fetcher.(SymbolLister).ListSymbols(context.Background(), zap.NewNop())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, symbols, Symbols{Bases: []string{"BTC", "EUR", "USD"}, Targets: []string{"BTC", "EUR", "USD"}})
+}
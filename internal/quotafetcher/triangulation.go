@@ -0,0 +1,117 @@
+package quotafetcher
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/GlazedCurd/PlataTest/internal/model"
+	"github.com/GlazedCurd/PlataTest/internal/rategraph"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+)
+
+// triangulatingQuotaFetcher quotes pairs the inner fetcher can't quote
+// directly by chaining rates through hub currencies, e.g. USD_MXN as
+// USD -> EUR -> MXN from EUR_USD and EUR_MXN.
+type triangulatingQuotaFetcher struct {
+	inner BatchQuotaFetcher
+	hubs  []string
+}
+
+func NewTriangulatingQuotaFetcher(inner BatchQuotaFetcher, hubs []string) BatchQuotaFetcher {
+	return &triangulatingQuotaFetcher{inner: inner, hubs: hubs}
+}
+
+func (q *triangulatingQuotaFetcher) FetchQuota(ctx context.Context, code string, logger *zap.Logger) (decimal.Decimal, error) {
+	return fetchOne(ctx, q, code, logger)
+}
+
+func (q *triangulatingQuotaFetcher) FetchQuotas(ctx context.Context, req BatchRequest, logger *zap.Logger) map[string]Result {
+	results := q.inner.FetchQuotas(ctx, req, logger)
+
+	var unsupported []string
+	for _, target := range req.Targets {
+		if Classify(results[target].Err) == ClassUnsupportedPair {
+			unsupported = append(unsupported, target)
+		}
+	}
+	if len(unsupported) == 0 {
+		return results
+	}
+
+	graph := rategraph.New()
+	// Dates of the rates in the graph by pair, for the date of the cross rates
+	dates := make(map[string]model.Date)
+	for target, result := range results {
+		if result.Err == nil {
+			graph.Add(req.Base, target, result.Price, result.Provider)
+			dates[req.Base+"_"+target] = result.Date
+		}
+	}
+	// Every hub is asked for all currencies involved, including the other hubs,
+	// so paths may go through more than one hub
+	currencies := append([]string{req.Base}, unsupported...)
+	currencies = append(currencies, q.hubs...)
+	for _, hub := range q.hubs {
+		if hub == req.Base {
+			continue
+		}
+		var targets []string
+		for _, currency := range currencies {
+			if currency != hub && !slices.Contains(targets, currency) {
+				targets = append(targets, currency)
+			}
+		}
+		hubLogger := logger.With(zap.String("hub", hub))
+		for target, result := range q.inner.FetchQuotas(ctx, BatchRequest{Base: hub, Targets: targets, AsOf: req.AsOf}, hubLogger) {
+			if result.Err != nil {
+				hubLogger.Debug("Hub rate unavailable", zap.String("target", target), zap.Error(result.Err))
+				continue
+			}
+			graph.Add(hub, target, result.Price, result.Provider)
+			dates[hub+"_"+target] = result.Date
+		}
+	}
+
+	for _, target := range unsupported {
+		conversion, ok := graph.Convert(req.Base, target)
+		if !ok {
+			results[target] = Result{Err: fmt.Errorf("no conversion path through %s: %w", strings.Join(q.hubs, ","), results[target].Err)}
+			continue
+		}
+		logger.Info("Triangulated rate", zap.String("target", target), zap.Strings("path", conversion.Path))
+		results[target] = Result{
+			Price:    conversion.Rate,
+			Date:     hopsDate(conversion.Hops, dates),
+			Provider: hopSources(conversion.Hops),
+			Path:     conversion.Path,
+		}
+	}
+	return results
+}
+
+// hopsDate returns the date of the oldest rate used along a conversion.
+func hopsDate(hops []rategraph.Hop, dates map[string]model.Date) model.Date {
+	var res []model.Date
+	for _, hop := range hops {
+		if hop.Inverse {
+			res = append(res, dates[hop.To+"_"+hop.From])
+		} else {
+			res = append(res, dates[hop.From+"_"+hop.To])
+		}
+	}
+	return earliestDate(res...)
+}
+
+// hopSources lists the distinct providers used along a conversion.
+func hopSources(hops []rategraph.Hop) string {
+	var sources []string
+	for _, hop := range hops {
+		if hop.Source != "" && !slices.Contains(sources, hop.Source) {
+			sources = append(sources, hop.Source)
+		}
+	}
+	return strings.Join(sources, ",")
+}
@@ -0,0 +1,41 @@
+package quotafetcher
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+)
+
+func TestTriangulationThroughHub(t *testing.T) {
+	restricted := fmt.Errorf("base_currency_access_restricted: %w", ErrUnsupportedPair)
+	inner := NewFailoverQuotaFetcher([]Provider{{Name: "exchangeratesapi", Fetcher: &batchMock{
+		fetchQuotas: func(ctx context.Context, req BatchRequest, logger *zap.Logger) map[string]Result {
+			if req.Base != "EUR" {
+				return failAll(req, restricted)
+			}
+			rates := map[string]float64{"USD": 1.25, "MXN": 20}
+			results := make(map[string]Result)
+			for _, target := range req.Targets {
+				rate, ok := rates[target]
+				if !ok {
+					results[target] = Result{Err: fmt.Errorf("rate not found: %w", ErrUnsupportedPair)}
+					continue
+				}
+				results[target] = Result{Price: decimal.NewFromFloat(rate)}
+			}
+			return results
+		},
+	}}})
+	fetcher := NewTriangulatingQuotaFetcher(inner, []string{"EUR"})
+
+	result := fetcher.FetchQuotas(context.Background(), BatchRequest{Base: "USD", Targets: []string{"MXN", "XXX"}}, zap.NewNop())
+
+	assertPrice(t, result["MXN"].Price, "16")
+	assert.Equal(t, result["MXN"].Provider, "exchangeratesapi")
+	assert.Equal(t, result["MXN"].Path, []string{"USD", "EUR", "MXN"})
+	assert.Equal(t, Classify(result["XXX"].Err), ClassUnsupportedPair)
+}
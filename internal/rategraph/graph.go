@@ -0,0 +1,110 @@
+package rategraph
+
+import (
+	"sort"
+
+	"github.com/GlazedCurd/PlataTest/internal/model"
+	"github.com/shopspring/decimal"
+)
+
+// Hop is one conversion step: 1 From buys Rate To.
+type Hop struct {
+	From string
+	To   string
+	Rate decimal.Decimal
+	// Inverse is set when the hop uses a To_From rate backwards.
+	Inverse bool
+	// Source tells where the underlying rate came from, e.g. a provider name.
+	Source string
+}
+
+// Conversion is a chain of hops between two currencies.
+type Conversion struct {
+	// Path lists the currencies visited, both ends included.
+	Path []string
+	Hops []Hop
+	// Rate is the cross rate: the product of the hop rates.
+	Rate decimal.Decimal
+}
+
+// Graph holds known direct exchange rates. Every rate is usable in both directions.
+type Graph struct {
+	edges map[string]map[string]Hop
+}
+
+func New() *Graph {
+	return &Graph{edges: make(map[string]map[string]Hop)}
+}
+
+// Add records that 1 from buys rate to. A later rate for the same pair replaces the earlier one.
+// The opposite direction uses the inverse rate, kept with twice model.PriceScale
+// so that the cross rates built from it are precise.
+func (g *Graph) Add(from, to string, rate decimal.Decimal, source string) {
+	if !rate.IsPositive() || from == to {
+		return
+	}
+	g.addHop(Hop{From: from, To: to, Rate: rate, Source: source})
+	g.addHop(Hop{From: to, To: from, Rate: decimal.NewFromInt(1).DivRound(rate, 2*model.PriceScale), Inverse: true, Source: source})
+}
+
+func (g *Graph) addHop(hop Hop) {
+	if existing, ok := g.edges[hop.From][hop.To]; ok && !existing.Inverse && hop.Inverse {
+		// Prefer a quoted rate over the inverse of the opposite one
+		return
+	}
+	if g.edges[hop.From] == nil {
+		g.edges[hop.From] = make(map[string]Hop)
+	}
+	g.edges[hop.From][hop.To] = hop
+}
+
+// Convert finds the conversion from -> to with the fewest hops.
+func (g *Graph) Convert(from, to string) (Conversion, bool) {
+	if from == to {
+		return Conversion{Path: []string{from}, Rate: decimal.NewFromInt(1)}, true
+	}
+	prev := map[string]Hop{}
+	visited := map[string]bool{from: true}
+	queue := []string{from}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, next := range g.neighbours(current) {
+			if visited[next] {
+				continue
+			}
+			visited[next] = true
+			prev[next] = g.edges[current][next]
+			if next == to {
+				return g.conversion(from, to, prev), true
+			}
+			queue = append(queue, next)
+		}
+	}
+	return Conversion{}, false
+}
+
+// neighbours are sorted to keep the chosen path stable between calls.
+func (g *Graph) neighbours(currency string) []string {
+	res := make([]string, 0, len(g.edges[currency]))
+	for next := range g.edges[currency] {
+		res = append(res, next)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (g *Graph) conversion(from, to string, prev map[string]Hop) Conversion {
+	var hops []Hop
+	for current := to; current != from; current = prev[current].From {
+		hops = append([]Hop{prev[current]}, hops...)
+	}
+	rate := decimal.NewFromInt(1)
+	conversion := Conversion{Path: []string{from}, Hops: hops}
+	for _, hop := range hops {
+		conversion.Path = append(conversion.Path, hop.To)
+		rate = rate.Mul(hop.Rate)
+	}
+	conversion.Rate = rate.Round(model.PriceScale)
+	return conversion
+}
@@ -0,0 +1,46 @@
+package rategraph
+
+import (
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/shopspring/decimal"
+)
+
+func TestConvertThroughInverse(t *testing.T) {
+	g := New()
+	g.Add("EUR", "USD", decimal.RequireFromString("1.25"), "ecb")
+	g.Add("EUR", "MXN", decimal.RequireFromString("20"), "ecb")
+
+	conversion, ok := g.Convert("USD", "MXN")
+
+	assert.Equal(t, ok, true)
+	assert.Equal(t, conversion.Path, []string{"USD", "EUR", "MXN"})
+	assert.Equal(t, conversion.Rate.String(), "16")
+	assert.Equal(t, conversion.Hops[0].Inverse, true)
+	assert.Equal(t, conversion.Hops[1].Inverse, false)
+}
+
+func TestConvertShortestPath(t *testing.T) {
+	g := New()
+	g.Add("USD", "EUR", decimal.RequireFromString("0.8"), "a")
+	g.Add("EUR", "GBP", decimal.RequireFromString("0.9"), "a")
+	g.Add("GBP", "JPY", decimal.RequireFromString("200"), "a")
+	g.Add("USD", "JPY", decimal.RequireFromString("150"), "b")
+
+	conversion, ok := g.Convert("USD", "JPY")
+
+	assert.Equal(t, ok, true)
+	assert.Equal(t, conversion.Path, []string{"USD", "JPY"})
+	assert.Equal(t, conversion.Rate.String(), "150")
+}
+
+func TestConvertNoPath(t *testing.T) {
+	g := New()
+	g.Add("EUR", "USD", decimal.RequireFromString("1.25"), "ecb")
+	g.Add("RUB", "KZT", decimal.RequireFromString("6"), "cbr")
+
+	_, ok := g.Convert("USD", "KZT")
+
+	assert.Equal(t, ok, false)
+}
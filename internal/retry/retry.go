@@ -0,0 +1,249 @@
+// Package retry runs operations that may fail transiently, waiting between
+// attempts according to a Policy. Waits are cut short by context cancellation.
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/GlazedCurd/PlataTest/internal/config"
+)
+
+// Jitter selects how delays between attempts are randomized.
+type Jitter int
+
+const (
+	// JitterNone doubles the delay after every attempt.
+	JitterNone Jitter = iota
+	// JitterFull picks a random delay up to the doubled one.
+	JitterFull
+	// JitterDecorrelated picks a random delay between InitialDelay and three
+	// times the previous one, spreading out clients that failed together.
+	JitterDecorrelated
+)
+
+func (j Jitter) String() string {
+	switch j {
+	case JitterFull:
+		return "full"
+	case JitterDecorrelated:
+		return "decorrelated"
+	default:
+		return "none"
+	}
+}
+
+// ParseJitter parses the name returned by Jitter.String.
+func ParseJitter(name string) (Jitter, error) {
+	switch strings.ToLower(name) {
+	case "none":
+		return JitterNone, nil
+	case "full":
+		return JitterFull, nil
+	case "decorrelated":
+		return JitterDecorrelated, nil
+	default:
+		return 0, fmt.Errorf("unknown jitter %q", name)
+	}
+}
+
+// Policy describes how an operation is retried. Zero limits are unbounded,
+// so a policy should set at least one of MaxAttempts and MaxElapsed unless
+// the context bounds it.
+type Policy struct {
+	// MaxAttempts is the total number of attempts, including the first one.
+	MaxAttempts  int
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	// MaxElapsed stops retrying once the next attempt would start later than
+	// this after the first one.
+	MaxElapsed time.Duration
+	Jitter     Jitter
+}
+
+// permanentError marks an error that must not be retried.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent stops Do from retrying err. Do returns err itself, unwrapped.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// afterError carries the server's hint on when to try again.
+type afterError struct {
+	err   error
+	after time.Duration
+}
+
+func (e *afterError) Error() string {
+	return e.err.Error()
+}
+
+func (e *afterError) Unwrap() error {
+	return e.err
+}
+
+// After asks Do to wait d before the next attempt instead of the policy's
+// delay, e.g. for a Retry-After header.
+func After(err error, d time.Duration) error {
+	if err == nil {
+		return nil
+	}
+	return &afterError{err: err, after: max(d, 0)}
+}
+
+// AfterHint returns the delay attached to err by After.
+func AfterHint(err error) (time.Duration, bool) {
+	var hinted *afterError
+	if errors.As(err, &hinted) {
+		return hinted.after, true
+	}
+	return 0, false
+}
+
+// Do calls op until it succeeds, returns an error marked Permanent or the policy
+// gives up. attempt starts at 1. When ctx is done Do stops waiting at once and
+// returns the last error of op along with the context's one.
+func (p Policy) Do(ctx context.Context, op func(ctx context.Context, attempt int) error) error {
+	start := time.Now()
+	backoff := p.backoff()
+	for attempt := 1; ; attempt++ {
+		err := op(ctx, attempt)
+		if err == nil {
+			return nil
+		}
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			return permanent.err
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return fmt.Errorf("%w: %w", ctxErr, err)
+		}
+		if p.MaxAttempts > 0 && attempt >= p.MaxAttempts {
+			return fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+
+		delay := backoff()
+		if hint, ok := AfterHint(err); ok {
+			delay = hint
+		}
+		next := time.Now().Add(delay)
+		if p.MaxElapsed > 0 && next.Sub(start) > p.MaxElapsed {
+			return fmt.Errorf("giving up after %s: %w", time.Since(start).Round(time.Millisecond), err)
+		}
+		if deadline, ok := ctx.Deadline(); ok && next.After(deadline) {
+			// Waiting would outlive the caller, so leave the retry to it.
+			return fmt.Errorf("giving up before deadline: %w", err)
+		}
+		if ctxErr := sleep(ctx, delay); ctxErr != nil {
+			return fmt.Errorf("%w: %w", ctxErr, err)
+		}
+	}
+}
+
+// Delay returns the wait after the given attempt, starting at 1, for callers
+// that schedule attempts themselves, e.g. a task queue. Jittered delays are
+// drawn afresh on every call.
+func (p Policy) Delay(attempt int) time.Duration {
+	backoff := p.backoff()
+	delay := backoff()
+	for i := 1; i < attempt; i++ {
+		delay = backoff()
+	}
+	return delay
+}
+
+// backoff returns a generator of delays between consecutive attempts.
+func (p Policy) backoff() func() time.Duration {
+	maxDelay := p.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = time.Duration(1<<63 - 1)
+	}
+	prev := time.Duration(0)
+	return func() time.Duration {
+		var delay time.Duration
+		switch {
+		case prev == 0:
+			delay = p.InitialDelay
+		case p.Jitter == JitterDecorrelated:
+			delay = p.InitialDelay + randDuration(scale(prev, 3, maxDelay)-p.InitialDelay)
+		default:
+			delay = scale(prev, 2, maxDelay)
+		}
+		delay = min(delay, maxDelay)
+		prev = delay
+		if p.Jitter == JitterFull {
+			return randDuration(delay)
+		}
+		return delay
+	}
+}
+
+// scale multiplies d by factor without overflowing past limit.
+func scale(d time.Duration, factor int64, limit time.Duration) time.Duration {
+	if d > limit/time.Duration(factor) {
+		return limit
+	}
+	return d * time.Duration(factor)
+}
+
+// randDuration returns a random duration in [0, d].
+func randDuration(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return rand.N(d + 1)
+}
+
+// sleep waits for d unless ctx is done first.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// FromEnv overrides the fields of def with the environment variables
+// <prefix>_MAX_ATTEMPTS, <prefix>_INITIAL_DELAY, <prefix>_MAX_DELAY,
+// <prefix>_MAX_ELAPSED and <prefix>_JITTER.
+func FromEnv(prefix string, def Policy) (Policy, error) {
+	policy := def
+	var err error
+	if policy.MaxAttempts, err = config.Int(prefix+"_MAX_ATTEMPTS", def.MaxAttempts); err != nil {
+		return Policy{}, err
+	}
+	if policy.InitialDelay, err = config.Duration(prefix+"_INITIAL_DELAY", def.InitialDelay); err != nil {
+		return Policy{}, err
+	}
+	if policy.MaxDelay, err = config.Duration(prefix+"_MAX_DELAY", def.MaxDelay); err != nil {
+		return Policy{}, err
+	}
+	if policy.MaxElapsed, err = config.Duration(prefix+"_MAX_ELAPSED", def.MaxElapsed); err != nil {
+		return Policy{}, err
+	}
+	if policy.Jitter, err = ParseJitter(config.String(prefix+"_JITTER", def.Jitter.String())); err != nil {
+		return Policy{}, fmt.Errorf("invalid %s_JITTER value: %w", prefix, err)
+	}
+	return policy, nil
+}
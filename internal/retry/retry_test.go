@@ -0,0 +1,150 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+)
+
+var errTransient = errors.New("transient")
+
+func TestBackoff(t *testing.T) {
+	next := Policy{InitialDelay: time.Second, MaxDelay: 5 * time.Second}.backoff()
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		assert.Equal(t, next(), want)
+	}
+}
+
+func TestBackoffJitterBounds(t *testing.T) {
+	for _, jitter := range []Jitter{JitterFull, JitterDecorrelated} {
+		next := Policy{InitialDelay: time.Second, MaxDelay: 10 * time.Second, Jitter: jitter}.backoff()
+		for i := 0; i < 100; i++ {
+			delay := next()
+			assert.Equal(t, delay >= 0 && delay <= 10*time.Second, true)
+			if jitter == JitterDecorrelated {
+				assert.Equal(t, delay >= time.Second, true)
+			}
+		}
+	}
+}
+
+func TestDelay(t *testing.T) {
+	policy := Policy{InitialDelay: 30 * time.Second, MaxDelay: 3 * time.Minute}
+	assert.Equal(t, policy.Delay(1), 30*time.Second)
+	assert.Equal(t, policy.Delay(2), time.Minute)
+	assert.Equal(t, policy.Delay(3), 2*time.Minute)
+	assert.Equal(t, policy.Delay(4), 3*time.Minute)
+	assert.Equal(t, policy.Delay(100), 3*time.Minute)
+
+	policy.Jitter = JitterDecorrelated
+	assert.Equal(t, policy.Delay(1), 30*time.Second)
+	for attempt := 2; attempt < 10; attempt++ {
+		delay := policy.Delay(attempt)
+		assert.Equal(t, delay >= 30*time.Second && delay <= 3*time.Minute, true)
+	}
+}
+
+func TestDoStopsAfterMaxAttempts(t *testing.T) {
+	calls := 0
+	err := Policy{MaxAttempts: 3}.Do(context.Background(), func(ctx context.Context, attempt int) error {
+		calls++
+		assert.Equal(t, attempt, calls)
+		return errTransient
+	})
+	assert.Equal(t, calls, 3)
+	assert.Equal(t, errors.Is(err, errTransient), true)
+}
+
+func TestDoSucceedsAfterRetry(t *testing.T) {
+	calls := 0
+	err := Policy{MaxAttempts: 3}.Do(context.Background(), func(ctx context.Context, attempt int) error {
+		calls++
+		if calls < 2 {
+			return errTransient
+		}
+		return nil
+	})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, calls, 2)
+}
+
+func TestDoNonRetryable(t *testing.T) {
+	errFatal := errors.New("fatal")
+	tests := []struct {
+		name   string
+		policy Policy
+		err    error
+	}{
+		{name: "permanent", policy: Policy{MaxAttempts: 5}, err: Permanent(errFatal)},
+		{name: "permanent wrapped", policy: Policy{MaxAttempts: 5}, err: fmt.Errorf("fetch: %w", Permanent(errFatal))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := tt.policy.Do(context.Background(), func(ctx context.Context, attempt int) error {
+				calls++
+				return tt.err
+			})
+			assert.Equal(t, calls, 1)
+			assert.Equal(t, err, errFatal)
+		})
+	}
+}
+
+func TestDoCanceledWhileWaiting(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	start := time.Now()
+	err := Policy{InitialDelay: time.Hour}.Do(ctx, func(ctx context.Context, attempt int) error {
+		return errTransient
+	})
+	assert.Equal(t, errors.Is(err, context.Canceled), true)
+	assert.Equal(t, errors.Is(err, errTransient), true)
+	assert.Equal(t, time.Since(start) < time.Second, true)
+}
+
+func TestDoAfterHint(t *testing.T) {
+	calls := 0
+	start := time.Now()
+	err := Policy{MaxAttempts: 2, InitialDelay: time.Hour}.Do(context.Background(), func(ctx context.Context, attempt int) error {
+		calls++
+		if calls == 1 {
+			return After(errTransient, time.Millisecond)
+		}
+		return nil
+	})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, time.Since(start) < time.Second, true)
+}
+
+func TestDoMaxElapsed(t *testing.T) {
+	calls := 0
+	err := Policy{InitialDelay: time.Minute, MaxElapsed: time.Second}.Do(context.Background(), func(ctx context.Context, attempt int) error {
+		calls++
+		return errTransient
+	})
+	assert.Equal(t, calls, 1)
+	assert.Equal(t, errors.Is(err, errTransient), true)
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("TEST_RETRY_MAX_ATTEMPTS", "7")
+	t.Setenv("TEST_RETRY_MAX_DELAY", "3s")
+	t.Setenv("TEST_RETRY_JITTER", "full")
+
+	policy, err := FromEnv("TEST_RETRY", Policy{MaxAttempts: 1, InitialDelay: time.Second, MaxDelay: time.Second})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, policy.MaxAttempts, 7)
+	assert.Equal(t, policy.InitialDelay, time.Second)
+	assert.Equal(t, policy.MaxDelay, 3*time.Second)
+	assert.Equal(t, policy.Jitter, JitterFull)
+
+	t.Setenv("TEST_RETRY_JITTER", "sometimes")
+	_, err = FromEnv("TEST_RETRY", Policy{})
+	assert.NotEqual(t, err, nil)
+}
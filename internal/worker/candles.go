@@ -0,0 +1,73 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/GlazedCurd/PlataTest/internal/db"
+	"github.com/GlazedCurd/PlataTest/internal/model"
+	"go.uber.org/zap"
+)
+
+// candleRollupTimeout bounds a single rollup of all intervals.
+const candleRollupTimeout = 5 * time.Minute
+
+// CandleRollup keeps the candles rollup table up to date, so that long OHLC
+// ranges are read from it instead of being aggregated from the quotes.
+type CandleRollup struct {
+	db        db.DB
+	log       *zap.Logger
+	intervals []model.CandleInterval
+	period    time.Duration
+}
+
+func NewCandleRollup(db db.DB, intervals []model.CandleInterval, period time.Duration, logger *zap.Logger) *CandleRollup {
+	return &CandleRollup{db: db, intervals: intervals, period: period, log: logger}
+}
+
+// ParseCandleIntervals parses a comma-separated list of candle widths, e.g. "1h,1d".
+func ParseCandleIntervals(value string) ([]model.CandleInterval, error) {
+	var intervals []model.CandleInterval
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		interval, ok := model.ParseCandleInterval(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown candle interval %q", name)
+		}
+		intervals = append(intervals, interval)
+	}
+	return intervals, nil
+}
+
+// Run rolls up the candles right away and then every period until ctx is done.
+func (r *CandleRollup) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.period)
+	defer ticker.Stop()
+	for {
+		r.rollup(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *CandleRollup) rollup(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, candleRollupTimeout)
+	defer cancel()
+
+	for _, interval := range r.intervals {
+		rolledUp, err := r.db.RollupCandles(ctx, interval)
+		if err != nil {
+			r.log.Error("Roll up candles", zap.String("interval", interval.Name), zap.Error(err))
+			continue
+		}
+		r.log.Info("Candles rolled up", zap.String("interval", interval.Name), zap.Int64("candles", rolledUp))
+	}
+}
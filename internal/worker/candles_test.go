@@ -0,0 +1,22 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func TestParseCandleIntervals(t *testing.T) {
+	intervals, err := ParseCandleIntervals("1h, 4h,1d")
+	if err != nil {
+		t.Fatalf("parse candle intervals %s", err)
+	}
+	assert.Equal(t, len(intervals), 3)
+	assert.Equal(t, intervals[1].Unit, "hour")
+	assert.Equal(t, intervals[1].Duration(), 4*time.Hour)
+	assert.Equal(t, intervals[2].Duration(), 24*time.Hour)
+
+	_, err = ParseCandleIntervals("1h,2h")
+	assert.Equal(t, err != nil, true)
+}
@@ -0,0 +1,152 @@
+package worker
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/GlazedCurd/PlataTest/internal/currency"
+	"github.com/GlazedCurd/PlataTest/internal/db"
+	"github.com/GlazedCurd/PlataTest/internal/model"
+	"github.com/GlazedCurd/PlataTest/internal/quotafetcher"
+	"go.uber.org/zap"
+)
+
+// catalogSyncTimeout bounds a single sync with all providers.
+const catalogSyncTimeout = 2 * time.Minute
+
+// CatalogSource is a provider whose symbols feed the currencies catalog.
+type CatalogSource struct {
+	Name   string
+	Lister quotafetcher.SymbolLister
+}
+
+// CatalogSyncer keeps the currencies catalog in line with what the providers quote.
+type CatalogSyncer struct {
+	db       db.DB
+	log      *zap.Logger
+	sources  []CatalogSource
+	interval time.Duration
+}
+
+func NewCatalogSyncer(db db.DB, sources []CatalogSource, interval time.Duration, logger *zap.Logger) *CatalogSyncer {
+	return &CatalogSyncer{db: db, sources: sources, interval: interval, log: logger}
+}
+
+// Run syncs the catalog right away and then every interval until ctx is done.
+func (s *CatalogSyncer) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.sync(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *CatalogSyncer) sync(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, catalogSyncTimeout)
+	defer cancel()
+
+	listed := make(map[string]quotafetcher.Symbols, len(s.sources))
+	for _, source := range s.sources {
+		symbols, err := source.Lister.ListSymbols(ctx, s.log.With(zap.String("provider", source.Name)))
+		if err != nil {
+			s.log.Error("List provider symbols, keeping the previous ones", zap.String("provider", source.Name), zap.Error(err))
+			continue
+		}
+		listed[source.Name] = symbols
+	}
+	if len(listed) == 0 {
+		return
+	}
+
+	previous, err := s.db.GetCurrencies(ctx)
+	if err != nil {
+		s.log.Error("Get currencies", zap.Error(err))
+		return
+	}
+	catalog := buildCatalog(previous, s.sources, listed)
+	replaced, err := s.db.ReplaceCurrencies(ctx, catalog)
+	if err != nil {
+		s.log.Error("Replace currencies", zap.Error(err))
+		return
+	}
+	if !replaced {
+		s.log.Info("Currencies catalog is being synced by another replica")
+		return
+	}
+	s.log.Info("Currencies catalog synced", zap.Int("currencies", len(catalog)), zap.Int("providers", len(listed)))
+}
+
+// buildCatalog merges the symbols listed by providers into a catalog. Providers
+// that failed to list their symbols keep their entries from the previous
+// catalog, providers no longer configured are dropped. Codes outside ISO 4217
+// can't be requested, so they are skipped.
+func buildCatalog(previous []model.Currency, sources []CatalogSource, listed map[string]quotafetcher.Symbols) []model.Currency {
+	bases := make(map[string][]string)
+	targets := make(map[string][]string)
+	for _, source := range sources {
+		if symbols, ok := listed[source.Name]; ok {
+			for _, code := range symbols.Bases {
+				bases[code] = append(bases[code], source.Name)
+			}
+			for _, code := range symbols.Targets {
+				targets[code] = append(targets[code], source.Name)
+			}
+			continue
+		}
+		for _, c := range previous {
+			for _, provider := range c.BaseProviders {
+				if provider == source.Name {
+					bases[c.Code] = append(bases[c.Code], provider)
+				}
+			}
+			for _, provider := range c.TargetProviders {
+				if provider == source.Name {
+					targets[c.Code] = append(targets[c.Code], provider)
+				}
+			}
+		}
+	}
+
+	var catalog []model.Currency
+	seen := make(map[string]bool)
+	for _, providers := range []map[string][]string{bases, targets} {
+		for code := range providers {
+			if seen[code] {
+				continue
+			}
+			seen[code] = true
+			iso, ok := currency.Lookup(code)
+			if !ok {
+				continue
+			}
+			entry := model.Currency{
+				Code:            iso.Code,
+				Name:            iso.Name,
+				BaseProviders:   emptyIfNil(bases[code]),
+				TargetProviders: emptyIfNil(targets[code]),
+			}
+			if iso.MinorUnits != currency.NoMinorUnits {
+				minorUnits := iso.MinorUnits
+				entry.MinorUnits = &minorUnits
+			}
+			catalog = append(catalog, entry)
+		}
+	}
+	sort.Slice(catalog, func(i, j int) bool {
+		return catalog[i].Code < catalog[j].Code
+	})
+	return catalog
+}
+
+func emptyIfNil(providers []string) []string {
+	if providers == nil {
+		return []string{}
+	}
+	return providers
+}
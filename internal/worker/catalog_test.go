@@ -0,0 +1,31 @@
+package worker
+
+import (
+	"testing"
+
+	"github.com/GlazedCurd/PlataTest/internal/model"
+	"github.com/GlazedCurd/PlataTest/internal/quotafetcher"
+	"github.com/go-playground/assert/v2"
+)
+
+func TestBuildCatalog(t *testing.T) {
+	sources := []CatalogSource{{Name: "exchangeratesapi"}, {Name: "ecb"}}
+	previous := []model.Currency{
+		{Code: "EUR", BaseProviders: []string{"cbr", "ecb"}, TargetProviders: []string{"cbr", "ecb"}},
+		{Code: "HUF", BaseProviders: []string{"ecb"}, TargetProviders: []string{"ecb"}},
+		{Code: "RUB", BaseProviders: []string{"cbr"}, TargetProviders: []string{"cbr"}},
+	}
+	listed := map[string]quotafetcher.Symbols{
+		// ecb failed to list its symbols this time
+		"exchangeratesapi": {Bases: []string{"EUR"}, Targets: []string{"BTC", "EUR", "XAU"}},
+	}
+
+	catalog := buildCatalog(previous, sources, listed)
+
+	two := 2
+	assert.Equal(t, catalog, []model.Currency{
+		{Code: "EUR", Name: "Euro", MinorUnits: &two, BaseProviders: []string{"exchangeratesapi", "ecb"}, TargetProviders: []string{"exchangeratesapi", "ecb"}},
+		{Code: "HUF", Name: "Forint", MinorUnits: &two, BaseProviders: []string{"ecb"}, TargetProviders: []string{"ecb"}},
+		{Code: "XAU", Name: "Gold", BaseProviders: []string{}, TargetProviders: []string{"exchangeratesapi"}},
+	})
+}
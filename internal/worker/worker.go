@@ -2,81 +2,344 @@ package worker
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/GlazedCurd/PlataTest/internal/db"
 	"github.com/GlazedCurd/PlataTest/internal/model"
 	"github.com/GlazedCurd/PlataTest/internal/quotafetcher"
+	"github.com/GlazedCurd/PlataTest/internal/retry"
 	"go.uber.org/zap"
 )
 
+// releaseTimeout bounds returning claimed tasks to the queue on shutdown.
+const releaseTimeout = 10 * time.Second
+
+type Config struct {
+	// ID identifies this worker replica in the claimed_by column.
+	ID         string
+	Tick       time.Duration
+	NumWorkers int
+	// BatchSize limits how many tasks are claimed per iteration.
+	BatchSize int
+	// LeaseDuration is how long claimed tasks belong to this worker.
+	LeaseDuration time.Duration
+	// ReapInterval is how often expired leases are returned to the queue.
+	ReapInterval time.Duration
+	// Retry limits the attempts of a task and spaces them out in the queue.
+	// Its MaxElapsed is not used, a zero MaxAttempts retries a task forever.
+	Retry retry.Policy
+	// ShutdownGracePeriod is how long in-flight tasks may run after shutdown is requested.
+	ShutdownGracePeriod time.Duration
+}
+
 type Worker struct {
 	db           db.DB
 	log          *zap.Logger
-	tick         time.Duration
-	quotaFetcher quotafetcher.QuotaFetcher
-	numWorkers   int
+	cfg          Config
+	quotaFetcher quotafetcher.BatchQuotaFetcher
 }
 
-func NewWorker(db db.DB, tick time.Duration, numWorkers int, logger *zap.Logger, quotaFetcher quotafetcher.QuotaFetcher) *Worker {
-	return &Worker{db: db, tick: tick, numWorkers: numWorkers, log: logger, quotaFetcher: quotaFetcher}
+func NewWorker(db db.DB, cfg Config, logger *zap.Logger, quotaFetcher quotafetcher.BatchQuotaFetcher) *Worker {
+	return &Worker{db: db, cfg: cfg, log: logger.With(zap.String("worker_id", cfg.ID)), quotaFetcher: quotaFetcher}
 }
 
-func (w *Worker) worker(ctx context.Context, task chan *model.Task, wg *sync.WaitGroup) {
-	defer wg.Done()
-	for task := range task {
-		w.log.Info("Processing task", zap.Any("task", task))
-		quota, err := w.quotaFetcher.FetchQuota(ctx, task.Code, w.log.With(zap.Uint64("task_id", task.ID)))
+func (w *Worker) updateTask(ctx context.Context, task *model.Task) {
+	_, err := w.db.UpdateTask(ctx, task)
+	if err != nil {
+		if errors.Is(err, db.ErrorNotFound) {
+			w.log.Warn("Task lease lost", zap.Uint64("task_id", task.ID))
+			return
+		}
+		w.log.Error("Task quote status", zap.Error(err))
+	}
+}
+
+// handleFailure schedules another attempt for the task or, when the error is
+// permanent or attempts are exhausted, finishes it as failed or dead.
+func (w *Worker) handleFailure(ctx context.Context, task *model.Task, fetchErr error) {
+	lastError := fetchErr.Error()
+	errorCode := errorCode(fetchErr)
+	task.LastError = &lastError
+	task.ErrorCode = &errorCode
+	switch {
+	case errors.Is(fetchErr, quotafetcher.ErrNonRetryable):
+		task.Status = model.STATUS_FAILED
+	case w.cfg.Retry.MaxAttempts > 0 && task.Attempts >= w.cfg.Retry.MaxAttempts:
+		task.Status = model.STATUS_DEAD
+	default:
+		delay := w.cfg.Retry.Delay(task.Attempts)
+		_, err := w.db.RetryTask(ctx, task, delay)
 		if err != nil {
-			w.log.Error("Fetching quota", zap.Error(err))
-			task.Status = model.STATUS_FAILED
-			_, err = w.db.UpdateTask(ctx, task)
-			if err != nil {
-				w.log.Error("Task quote status", zap.Error(err))
+			if errors.Is(err, db.ErrorNotFound) {
+				w.log.Warn("Task lease lost", zap.Uint64("task_id", task.ID))
+				return
 			}
+			w.log.Error("Retry task", zap.Error(err))
+			return
+		}
+		w.log.Info("Task scheduled for retry", zap.Uint64("task_id", task.ID), zap.Int("attempts", task.Attempts), zap.Duration("delay", delay))
+		return
+	}
+	w.log.Warn("Task finished without quota", zap.Uint64("task_id", task.ID), zap.String("status", task.Status), zap.Int("attempts", task.Attempts))
+	w.updateTask(ctx, task)
+}
+
+var (
+	errInvalidPair       = fmt.Errorf("invalid code format: %w", quotafetcher.ErrNonRetryable)
+	errAttemptsExhausted = errors.New("attempts exhausted without result")
+)
+
+// errorCode returns the code stored with a failed task for clients to act on.
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, errInvalidPair):
+		return model.ERROR_INVALID_PAIR
+	case errors.Is(err, errAttemptsExhausted):
+		return model.ERROR_ATTEMPTS_EXHAUSTED
+	default:
+		return quotafetcher.ErrorCode(err)
+	}
+}
+
+// quoteBatch is a group of tasks sharing the base currency and the requested
+// day, fetched with one upstream call.
+type quoteBatch struct {
+	base  string
+	asOf  model.Date
+	tasks []*model.Task
+}
+
+// targets returns the distinct quote currencies requested by the batch.
+func (b *quoteBatch) targets() []string {
+	var targets []string
+	seen := make(map[string]bool)
+	for _, task := range b.tasks {
+		target := strings.Split(task.Code, "_")[1]
+		if !seen[target] {
+			seen[target] = true
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// groupByBase splits tasks into batches by base currency and requested day,
+// keeping claim order. Tasks with a malformed code can't be batched and are
+// returned separately.
+func groupByBase(tasks []model.Task) ([]*quoteBatch, []*model.Task) {
+	type batchKey struct {
+		base string
+		asOf model.Date
+	}
+	var batches []*quoteBatch
+	var malformed []*model.Task
+	byKey := make(map[batchKey]*quoteBatch)
+	for i := range tasks {
+		task := &tasks[i]
+		parts := strings.Split(task.Code, "_")
+		if len(parts) != 2 {
+			malformed = append(malformed, task)
 			continue
 		}
-		task.Price = &quota
-		task.Status = model.STATUS_SUCCESS
-		_, err = w.db.UpdateTask(ctx, task)
-		if err != nil {
-			w.log.Error("Task quote status", zap.Error(err))
+		key := batchKey{base: parts[0]}
+		if task.AsOf != nil {
+			key.asOf = *task.AsOf
+		}
+		batch, ok := byKey[key]
+		if !ok {
+			batch = &quoteBatch{base: key.base, asOf: key.asOf}
+			byKey[key] = batch
+			batches = append(batches, batch)
+		}
+		batch.tasks = append(batch.tasks, task)
+	}
+	return batches, malformed
+}
+
+func (w *Worker) worker(ctx context.Context, batches chan *quoteBatch, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for batch := range batches {
+		var tasks []*model.Task
+		for _, task := range batch.tasks {
+			w.log.Info("Processing task", zap.Any("task", task))
+			if w.cfg.Retry.MaxAttempts > 0 && task.Attempts > w.cfg.Retry.MaxAttempts {
+				// The task keeps coming back through expired leases, most likely it crashes the worker
+				w.handleFailure(ctx, task, errAttemptsExhausted)
+				continue
+			}
+			tasks = append(tasks, task)
+		}
+		if len(tasks) == 0 {
+			continue
+		}
+		batch.tasks = tasks
+
+		targets := batch.targets()
+		logger := w.log.With(zap.String("base", batch.base), zap.Strings("targets", targets))
+		if !batch.asOf.IsZero() {
+			logger = logger.With(zap.Stringer("as_of", batch.asOf))
+		}
+		results := w.quotaFetcher.FetchQuotas(ctx, quotafetcher.BatchRequest{Base: batch.base, Targets: targets, AsOf: batch.asOf}, logger)
+		if ctx.Err() != nil {
+			// Shutdown grace period or lease is over, the claim is released by the caller
+			logger.Warn("Batch interrupted", zap.Error(ctx.Err()))
+			continue
+		}
+		for _, task := range batch.tasks {
+			target := strings.Split(task.Code, "_")[1]
+			result, ok := results[target]
+			if !ok {
+				result.Err = fmt.Errorf("no result for currency: %s", target)
+			}
+			if result.Err != nil {
+				w.log.Error("Fetching quota", zap.Uint64("task_id", task.ID), zap.Error(result.Err))
+				w.handleFailure(ctx, task, result.Err)
+				continue
+			}
+			quota := result.Price
+			task.Price = &quota
+			task.Status = model.STATUS_SUCCESS
+			task.RateDate = nil
+			if !result.Date.IsZero() {
+				rateDate := result.Date
+				task.RateDate = &rateDate
+			}
+			task.LastError = nil
+			task.ErrorCode = nil
+			if result.Provider != "" {
+				task.Provider = &result.Provider
+			}
+			task.ConversionPath = result.Path
+			task.Contributions = result.Contributions
+			w.updateTask(ctx, task)
+			w.log.Info("Fetched quota", zap.Uint64("task_id", task.ID), zap.Any("quota", quota), zap.String("provider", result.Provider))
 		}
-		w.log.Info("Fetched quota", zap.Any("quota", quota))
 	}
 }
 
-func (w *Worker) doWork() {
-	ctx, cancel := context.WithTimeout(context.Background(), w.tick)
+// doWork claims a batch of tasks and processes it. Once ctx is done no more
+// tasks are dispatched, and the ones in flight get ShutdownGracePeriod to finish.
+func (w *Worker) doWork(ctx context.Context) {
+	// Tasks are only ours until the lease expires, so don't work past it
+	workCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), w.cfg.LeaseDuration)
 	defer cancel()
-	tasks, err := w.db.GetRecentlyTasksToProcess(ctx)
+	stopGrace := context.AfterFunc(ctx, func() {
+		time.AfterFunc(w.cfg.ShutdownGracePeriod, cancel)
+	})
+	defer stopGrace()
+
+	tasks, err := w.db.ClaimTasks(ctx, w.cfg.ID, w.cfg.BatchSize, w.cfg.LeaseDuration)
 	if err != nil {
-		w.log.Error("Get recently tasks to process", zap.Error(err))
+		w.log.Error("Claim tasks", zap.Error(err))
 		return
 	}
-	chanTasks := make(chan *model.Task)
+	w.log.Info("Claimed tasks", zap.Int("count", len(tasks)))
+
+	// Tasks with the same base currency share one upstream request
+	batches, malformed := groupByBase(tasks)
+	for _, task := range malformed {
+		w.handleFailure(workCtx, task, fmt.Errorf("%w: %s", errInvalidPair, task.Code))
+	}
+	chanBatches := make(chan *quoteBatch)
 
 	var wg sync.WaitGroup
-	for i := 0; i < w.numWorkers; i++ {
+	for i := 0; i < w.cfg.NumWorkers; i++ {
 		wg.Add(1)
-		go w.worker(ctx, chanTasks, &wg)
+		go w.worker(workCtx, chanBatches, &wg)
 	}
 
-	for _, task := range tasks {
-		chanTasks <- &task
+dispatch:
+	for _, batch := range batches {
+		select {
+		case chanBatches <- batch:
+		case <-ctx.Done():
+			w.log.Info("Stop dispatching claimed tasks")
+			break dispatch
+		}
 	}
-	close(chanTasks)
+	close(chanBatches)
 	wg.Wait()
 }
 
-func (w *Worker) Start() {
+// release returns tasks still claimed by this worker back to the queue.
+func (w *Worker) release() {
+	ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+	released, err := w.db.ReleaseTasks(ctx, w.cfg.ID)
+	if err != nil {
+		w.log.Error("Release claimed tasks", zap.Error(err))
+		return
+	}
+	w.log.Info("Released claimed tasks", zap.Int64("count", released))
+}
+
+// reap returns tasks stranded by crashed replicas back to the queue.
+func (w *Worker) reap(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, w.cfg.ReapInterval)
+	defer cancel()
+	released, err := w.db.ReleaseExpiredTasks(ctx)
+	if err != nil {
+		w.log.Error("Release expired tasks", zap.Error(err))
+		return
+	}
+	if released > 0 {
+		w.log.Warn("Released tasks with expired lease", zap.Int64("count", released))
+	}
+}
+
+func (w *Worker) startReaper(ctx context.Context) {
+	ticker := time.NewTicker(w.cfg.ReapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.reap(ctx)
+		}
+	}
+}
+
+// Start processes tasks until ctx is done, then drains in-flight tasks,
+// releases unfinished claims and returns.
+func (w *Worker) Start(ctx context.Context) {
 	w.log.Info("Worker started")
 	defer w.log.Info("Worker stopped")
+	defer w.release()
 
-	ticker := time.Tick(w.tick)
-	for range ticker {
-		w.log.Info("Worker is working...")
-		w.doWork()
+	var reaper sync.WaitGroup
+	defer reaper.Wait()
+	reaper.Go(func() {
+		w.startReaper(ctx)
+	})
+
+	// New tasks wake the worker up immediately, the ticker is only a safety net
+	// for lost notifications and tasks waiting for their retry
+	newTasks, err := w.db.SubscribeNewTasks(ctx)
+	if err != nil {
+		w.log.Error("Subscribe to new tasks, falling back to polling", zap.Error(err))
+	}
+
+	ticker := time.NewTicker(w.cfg.Tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			w.log.Info("Worker is shutting down")
+			return
+		case <-ticker.C:
+			w.log.Info("Worker is working...")
+		case _, ok := <-newTasks:
+			if !ok {
+				newTasks = nil
+				continue
+			}
+			w.log.Info("Worker is woken up by new tasks")
+		}
+		w.doWork(ctx)
 	}
 }
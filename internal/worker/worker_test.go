@@ -0,0 +1,896 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GlazedCurd/PlataTest/internal/db"
+	"github.com/GlazedCurd/PlataTest/internal/model"
+	"github.com/GlazedCurd/PlataTest/internal/quotafetcher"
+	"github.com/GlazedCurd/PlataTest/internal/retry"
+	"github.com/go-playground/assert/v2"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+)
+
+type dbMock struct {
+	insertTask               func(ctx context.Context, task *model.Task) (*model.Task, error)
+	updateTask               func(ctx context.Context, task *model.Task) (*model.Task, error)
+	retryTask                func(ctx context.Context, task *model.Task, delay time.Duration) (*model.Task, error)
+	getTask                  func(ctx context.Context, code model.Code, taskId model.TaskId) (*model.Task, error)
+	getLastSuccessfulTask    func(ctx context.Context, code model.Code) (*model.Task, error)
+	getLatestSuccessfulTasks func(ctx context.Context, currencies []string) ([]model.Task, error)
+	getSuccessfulTaskAsOf    func(ctx context.Context, code model.Code, day model.Date) (*model.Task, error)
+	getTaskHistory           func(ctx context.Context, query model.HistoryQuery) ([]model.Task, error)
+	getCandles               func(ctx context.Context, query model.CandleQuery) ([]model.Candle, error)
+	rollupCandles            func(ctx context.Context, interval model.CandleInterval) (int64, error)
+	claimTasks               func(ctx context.Context, workerId string, limit int, lease time.Duration) ([]model.Task, error)
+	releaseExpiredTasks      func(ctx context.Context) (int64, error)
+	releaseTasks             func(ctx context.Context, workerId string) (int64, error)
+	subscribeNewTasks        func(ctx context.Context) (<-chan struct{}, error)
+	getCurrencies            func(ctx context.Context) ([]model.Currency, error)
+	getCurrency              func(ctx context.Context, code string) (*model.Currency, error)
+	replaceCurrencies        func(ctx context.Context, currencies []model.Currency) (bool, error)
+	unsupportedCurrencies    func(ctx context.Context, codes []string) ([]string, error)
+}
+
+func NewDbMock() *dbMock {
+	return &dbMock{
+		insertTask: func(ctx context.Context, task *model.Task) (*model.Task, error) {
+			return nil, nil
+		},
+		updateTask: func(ctx context.Context, task *model.Task) (*model.Task, error) {
+			return task, nil
+		},
+		retryTask: func(ctx context.Context, task *model.Task, delay time.Duration) (*model.Task, error) {
+			return task, nil
+		},
+		getTask: func(ctx context.Context, code model.Code, taskId model.TaskId) (*model.Task, error) {
+			return nil, db.ErrorNotFound
+		},
+		getLastSuccessfulTask: func(ctx context.Context, code model.Code) (*model.Task, error) {
+			return nil, db.ErrorNotFound
+		},
+		getLatestSuccessfulTasks: func(ctx context.Context, currencies []string) ([]model.Task, error) {
+			return nil, nil
+		},
+		getSuccessfulTaskAsOf: func(ctx context.Context, code model.Code, day model.Date) (*model.Task, error) {
+			return nil, db.ErrorNotFound
+		},
+		getTaskHistory: func(ctx context.Context, query model.HistoryQuery) ([]model.Task, error) {
+			return nil, nil
+		},
+		getCandles: func(ctx context.Context, query model.CandleQuery) ([]model.Candle, error) {
+			return nil, nil
+		},
+		rollupCandles: func(ctx context.Context, interval model.CandleInterval) (int64, error) {
+			return 0, nil
+		},
+		claimTasks: func(ctx context.Context, workerId string, limit int, lease time.Duration) ([]model.Task, error) {
+			return nil, nil
+		},
+		releaseExpiredTasks: func(ctx context.Context) (int64, error) {
+			return 0, nil
+		},
+		releaseTasks: func(ctx context.Context, workerId string) (int64, error) {
+			return 0, nil
+		},
+		subscribeNewTasks: func(ctx context.Context) (<-chan struct{}, error) {
+			return nil, errors.New("listen is not supported")
+		},
+		getCurrencies: func(ctx context.Context) ([]model.Currency, error) {
+			return nil, nil
+		},
+		getCurrency: func(ctx context.Context, code string) (*model.Currency, error) {
+			return nil, db.ErrorNotFound
+		},
+		replaceCurrencies: func(ctx context.Context, currencies []model.Currency) (bool, error) {
+			return true, nil
+		},
+		unsupportedCurrencies: func(ctx context.Context, codes []string) ([]string, error) {
+			return nil, nil
+		},
+	}
+}
+
+func (d *dbMock) Close() error {
+	return nil
+}
+
+func (d *dbMock) InsertTask(ctx context.Context, task *model.Task) (*model.Task, error) {
+	return d.insertTask(ctx, task)
+}
+
+func (d *dbMock) UpdateTask(ctx context.Context, task *model.Task) (*model.Task, error) {
+	return d.updateTask(ctx, task)
+}
+
+func (d *dbMock) RetryTask(ctx context.Context, task *model.Task, delay time.Duration) (*model.Task, error) {
+	return d.retryTask(ctx, task, delay)
+}
+
+func (d *dbMock) GetTask(ctx context.Context, code model.Code, taskId model.TaskId) (*model.Task, error) {
+	return d.getTask(ctx, code, taskId)
+}
+
+func (d *dbMock) GetLastSuccessfulTask(ctx context.Context, code model.Code) (*model.Task, error) {
+	return d.getLastSuccessfulTask(ctx, code)
+}
+
+func (d *dbMock) GetLatestSuccessfulTasks(ctx context.Context, currencies []string) ([]model.Task, error) {
+	return d.getLatestSuccessfulTasks(ctx, currencies)
+}
+
+func (d *dbMock) GetSuccessfulTaskAsOf(ctx context.Context, code model.Code, day model.Date) (*model.Task, error) {
+	return d.getSuccessfulTaskAsOf(ctx, code, day)
+}
+
+func (d *dbMock) GetTaskHistory(ctx context.Context, query model.HistoryQuery) ([]model.Task, error) {
+	return d.getTaskHistory(ctx, query)
+}
+
+func (d *dbMock) GetCandles(ctx context.Context, query model.CandleQuery) ([]model.Candle, error) {
+	return d.getCandles(ctx, query)
+}
+
+func (d *dbMock) RollupCandles(ctx context.Context, interval model.CandleInterval) (int64, error) {
+	return d.rollupCandles(ctx, interval)
+}
+
+func (d *dbMock) ClaimTasks(ctx context.Context, workerId string, limit int, lease time.Duration) ([]model.Task, error) {
+	return d.claimTasks(ctx, workerId, limit, lease)
+}
+
+func (d *dbMock) ReleaseExpiredTasks(ctx context.Context) (int64, error) {
+	return d.releaseExpiredTasks(ctx)
+}
+
+func (d *dbMock) ReleaseTasks(ctx context.Context, workerId string) (int64, error) {
+	return d.releaseTasks(ctx, workerId)
+}
+
+func (d *dbMock) SubscribeNewTasks(ctx context.Context) (<-chan struct{}, error) {
+	return d.subscribeNewTasks(ctx)
+}
+
+func (d *dbMock) GetCurrencies(ctx context.Context) ([]model.Currency, error) {
+	return d.getCurrencies(ctx)
+}
+
+func (d *dbMock) GetCurrency(ctx context.Context, code string) (*model.Currency, error) {
+	return d.getCurrency(ctx, code)
+}
+
+func (d *dbMock) ReplaceCurrencies(ctx context.Context, currencies []model.Currency) (bool, error) {
+	return d.replaceCurrencies(ctx, currencies)
+}
+
+func (d *dbMock) UnsupportedCurrencies(ctx context.Context, codes []string) ([]string, error) {
+	return d.unsupportedCurrencies(ctx, codes)
+}
+
+// taskCall is a write of a task the worker made through UpdateTask or RetryTask.
+type taskCall struct {
+	method    string
+	id        model.TaskId
+	status    string
+	errorCode string
+	delay     time.Duration
+}
+
+// recordTaskCalls makes the mock record task writes and answer them with
+// updateErr and retryErr.
+func recordTaskCalls(dbmock *dbMock, updateErr, retryErr error) func() []taskCall {
+	var mu sync.Mutex
+	var calls []taskCall
+	record := func(method string, task *model.Task, delay time.Duration) {
+		call := taskCall{method: method, id: task.ID, status: task.Status, delay: delay}
+		if task.ErrorCode != nil {
+			call.errorCode = *task.ErrorCode
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, call)
+	}
+	dbmock.updateTask = func(ctx context.Context, task *model.Task) (*model.Task, error) {
+		record("UpdateTask", task, 0)
+		return task, updateErr
+	}
+	dbmock.retryTask = func(ctx context.Context, task *model.Task, delay time.Duration) (*model.Task, error) {
+		record("RetryTask", task, delay)
+		return task, retryErr
+	}
+	return func() []taskCall {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]taskCall(nil), calls...)
+	}
+}
+
+type fetcherMock struct {
+	fetchQuotas func(ctx context.Context, req quotafetcher.BatchRequest) map[string]quotafetcher.Result
+}
+
+func (f *fetcherMock) FetchQuota(ctx context.Context, code string, logger *zap.Logger) (decimal.Decimal, error) {
+	return decimal.Decimal{}, errors.New("not implemented")
+}
+
+func (f *fetcherMock) FetchQuotas(ctx context.Context, req quotafetcher.BatchRequest, logger *zap.Logger) map[string]quotafetcher.Result {
+	return f.fetchQuotas(ctx, req)
+}
+
+// testRetry spaces attempts 30s, 1m, 2m, ... apart without jitter.
+var testRetry = retry.Policy{MaxAttempts: 3, InitialDelay: 30 * time.Second, MaxDelay: 10 * time.Minute}
+
+func TestDoWork(t *testing.T) {
+	price := decimal.RequireFromString("1.17")
+	tests := []struct {
+		name      string
+		updateErr error
+	}{
+		{name: "stored"},
+		{name: "lease lost", updateErr: db.ErrorNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbmock := NewDbMock()
+			calls := recordTaskCalls(dbmock, tt.updateErr, nil)
+			workerId := "worker-1"
+			dbmock.claimTasks = func(ctx context.Context, id string, limit int, lease time.Duration) ([]model.Task, error) {
+				assert.Equal(t, id, workerId)
+				assert.Equal(t, limit, 10)
+				assert.Equal(t, lease, time.Minute)
+				return []model.Task{
+					{ID: 1, Code: "EUR_USD", Status: model.STATUS_PROCESSING, ClaimedBy: &workerId, Attempts: 1},
+					{ID: 2, Code: "EUR_GBP", Status: model.STATUS_PROCESSING, ClaimedBy: &workerId, Attempts: 1},
+				}, nil
+			}
+			fetcher := &fetcherMock{fetchQuotas: func(ctx context.Context, req quotafetcher.BatchRequest) map[string]quotafetcher.Result {
+				assert.Equal(t, req, quotafetcher.BatchRequest{Base: "EUR", Targets: []string{"USD", "GBP"}})
+				return map[string]quotafetcher.Result{
+					"USD": {Price: price},
+					"GBP": {Err: errors.New("connection reset")},
+				}
+			}}
+			w := NewWorker(dbmock, Config{ID: workerId, NumWorkers: 1, BatchSize: 10, LeaseDuration: time.Minute, Retry: testRetry}, zap.NewNop(), fetcher)
+
+			w.doWork(context.Background())
+
+			assert.Equal(t, calls(), []taskCall{
+				{method: "UpdateTask", id: 1, status: model.STATUS_SUCCESS},
+				{method: "RetryTask", id: 2, status: model.STATUS_PROCESSING, errorCode: model.ERROR_PROVIDER_UNAVAILABLE, delay: 30 * time.Second},
+			})
+		})
+	}
+}
+
+func TestDoWorkClaimFails(t *testing.T) {
+	dbmock := NewDbMock()
+	calls := recordTaskCalls(dbmock, nil, nil)
+	dbmock.claimTasks = func(ctx context.Context, workerId string, limit int, lease time.Duration) ([]model.Task, error) {
+		return nil, errors.New("connection refused")
+	}
+	fetcher := &fetcherMock{fetchQuotas: func(ctx context.Context, req quotafetcher.BatchRequest) map[string]quotafetcher.Result {
+		t.Error("fetched without a claim")
+		return nil
+	}}
+	w := NewWorker(dbmock, Config{ID: "worker-1", NumWorkers: 1, BatchSize: 10, LeaseDuration: time.Minute, Retry: testRetry}, zap.NewNop(), fetcher)
+
+	w.doWork(context.Background())
+
+	assert.Equal(t, len(calls()), 0)
+}
+
+func TestHandleFailure(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   *retry.Policy
+		attempts int
+		err      error
+		retryErr error
+		calls    []taskCall
+	}{
+		{
+			name:     "retry",
+			attempts: 1,
+			err:      errors.New("connection reset"),
+			calls:    []taskCall{{method: "RetryTask", id: 1, status: model.STATUS_PROCESSING, errorCode: model.ERROR_PROVIDER_UNAVAILABLE, delay: 30 * time.Second}},
+		},
+		{
+			name:     "retry backs off",
+			attempts: 2,
+			err:      fmt.Errorf("fetch: %w", quotafetcher.ErrRateLimited),
+			calls:    []taskCall{{method: "RetryTask", id: 1, status: model.STATUS_PROCESSING, errorCode: model.ERROR_RATE_LIMITED, delay: time.Minute}},
+		},
+		{
+			name:     "failed",
+			attempts: 1,
+			err:      quotafetcher.ErrUnsupportedPair,
+			calls:    []taskCall{{method: "UpdateTask", id: 1, status: model.STATUS_FAILED, errorCode: model.ERROR_UNSUPPORTED_PAIR}},
+		},
+		{
+			name:     "invalid pair",
+			attempts: 1,
+			err:      errInvalidPair,
+			calls:    []taskCall{{method: "UpdateTask", id: 1, status: model.STATUS_FAILED, errorCode: model.ERROR_INVALID_PAIR}},
+		},
+		{
+			name:     "dead",
+			attempts: 3,
+			err:      errors.New("connection reset"),
+			calls:    []taskCall{{method: "UpdateTask", id: 1, status: model.STATUS_DEAD, errorCode: model.ERROR_PROVIDER_UNAVAILABLE}},
+		},
+		{
+			name:     "attempts exhausted",
+			attempts: 4,
+			err:      errAttemptsExhausted,
+			calls:    []taskCall{{method: "UpdateTask", id: 1, status: model.STATUS_DEAD, errorCode: model.ERROR_ATTEMPTS_EXHAUSTED}},
+		},
+		{
+			name:     "unbounded attempts",
+			policy:   &retry.Policy{InitialDelay: 30 * time.Second, MaxDelay: 10 * time.Minute},
+			attempts: 20,
+			err:      errors.New("connection reset"),
+			calls:    []taskCall{{method: "RetryTask", id: 1, status: model.STATUS_PROCESSING, errorCode: model.ERROR_PROVIDER_UNAVAILABLE, delay: 10 * time.Minute}},
+		},
+		{
+			name:     "lease lost",
+			attempts: 1,
+			err:      errors.New("connection reset"),
+			retryErr: db.ErrorNotFound,
+			calls:    []taskCall{{method: "RetryTask", id: 1, status: model.STATUS_PROCESSING, errorCode: model.ERROR_PROVIDER_UNAVAILABLE, delay: 30 * time.Second}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbmock := NewDbMock()
+			calls := recordTaskCalls(dbmock, nil, tt.retryErr)
+			policy := testRetry
+			if tt.policy != nil {
+				policy = *tt.policy
+			}
+			w := NewWorker(dbmock, Config{Retry: policy}, zap.NewNop(), &fetcherMock{})
+
+			task := &model.Task{ID: 1, Code: "EUR_USD", Status: model.STATUS_PROCESSING, Attempts: tt.attempts}
+			w.handleFailure(context.Background(), task, tt.err)
+
+			assert.Equal(t, calls(), tt.calls)
+			assert.Equal(t, *task.LastError, tt.err.Error())
+		})
+	}
+}
+
+func TestGroupByBase(t *testing.T) {
+	tasks := []model.Task{
+		{ID: 1, Code: "EUR_USD"},
+		{ID: 2, Code: "USD_MXN"},
+		{ID: 3, Code: "EUR_GBP"},
+		{ID: 4, Code: "foo"},
+		{ID: 5, Code: "EUR_USD"},
+	}
+
+	batches, malformed := groupByBase(tasks)
+
+	assert.Equal(t, len(batches), 2)
+	assert.Equal(t, batches[0].base, "EUR")
+	assert.Equal(t, len(batches[0].tasks), 3)
+	assert.Equal(t, batches[0].targets(), []string{"USD", "GBP"})
+	assert.Equal(t, batches[1].base, "USD")
+	assert.Equal(t, batches[1].targets(), []string{"MXN"})
+	assert.Equal(t, len(malformed), 1)
+	assert.Equal(t, malformed[0].ID, model.TaskId(4))
+}
+
+func TestGroupByBaseSplitsDays(t *testing.T) {
+	day, _ := model.ParseDate("2025-08-15")
+	tasks := []model.Task{
+		{ID: 1, Code: "EUR_USD"},
+		{ID: 2, Code: "EUR_USD", AsOf: &day},
+		{ID: 3, Code: "EUR_GBP", AsOf: &day},
+	}
+
+	batches, _ := groupByBase(tasks)
+
+	assert.Equal(t, len(batches), 2)
+	assert.Equal(t, batches[0].asOf.IsZero(), true)
+	assert.Equal(t, len(batches[0].tasks), 1)
+	assert.Equal(t, batches[1].asOf, day)
+	assert.Equal(t, batches[1].targets(), []string{"USD", "GBP"})
+}
+
+func TestWorkerProcessesBatch(t *testing.T) {
+	price := decimal.RequireFromString("1.17")
+	tests := []struct {
+		name      string
+		tasks     []model.Task
+		results   map[string]quotafetcher.Result
+		updateErr error
+		retryErr  error
+		// targets is nil when no upstream call is expected
+		targets []string
+		calls   []taskCall
+	}{
+		{
+			name: "split results",
+			tasks: []model.Task{
+				{ID: 1, Code: "EUR_USD", Attempts: 1},
+				{ID: 2, Code: "EUR_GBP", Attempts: 1},
+				{ID: 3, Code: "EUR_USD", Attempts: 2},
+				{ID: 4, Code: "EUR_JPY", Attempts: 1},
+				{ID: 5, Code: "EUR_CHF", Attempts: 1},
+			},
+			results: map[string]quotafetcher.Result{
+				"USD": {Price: price},
+				"GBP": {Err: errors.New("connection reset")},
+				"CHF": {Err: quotafetcher.ErrUnsupportedPair},
+				// JPY is missing
+			},
+			targets: []string{"USD", "GBP", "JPY", "CHF"},
+			calls: []taskCall{
+				{method: "UpdateTask", id: 1, status: model.STATUS_SUCCESS},
+				{method: "RetryTask", id: 2, status: model.STATUS_PROCESSING, errorCode: model.ERROR_PROVIDER_UNAVAILABLE, delay: 30 * time.Second},
+				{method: "UpdateTask", id: 3, status: model.STATUS_SUCCESS},
+				{method: "RetryTask", id: 4, status: model.STATUS_PROCESSING, errorCode: model.ERROR_PROVIDER_UNAVAILABLE, delay: 30 * time.Second},
+				{method: "UpdateTask", id: 5, status: model.STATUS_FAILED, errorCode: model.ERROR_UNSUPPORTED_PAIR},
+			},
+		},
+		{
+			name: "attempts exhausted",
+			tasks: []model.Task{
+				{ID: 1, Code: "EUR_USD", Attempts: 4},
+				{ID: 2, Code: "EUR_GBP", Attempts: 3},
+			},
+			results: map[string]quotafetcher.Result{"GBP": {Price: price}},
+			targets: []string{"GBP"},
+			calls: []taskCall{
+				{method: "UpdateTask", id: 1, status: model.STATUS_DEAD, errorCode: model.ERROR_ATTEMPTS_EXHAUSTED},
+				{method: "UpdateTask", id: 2, status: model.STATUS_SUCCESS},
+			},
+		},
+		{
+			name:  "only exhausted tasks",
+			tasks: []model.Task{{ID: 1, Code: "EUR_USD", Attempts: 4}},
+			calls: []taskCall{{method: "UpdateTask", id: 1, status: model.STATUS_DEAD, errorCode: model.ERROR_ATTEMPTS_EXHAUSTED}},
+		},
+		{
+			name:      "lease lost on success",
+			tasks:     []model.Task{{ID: 1, Code: "EUR_USD", Attempts: 1}},
+			results:   map[string]quotafetcher.Result{"USD": {Price: price}},
+			updateErr: db.ErrorNotFound,
+			targets:   []string{"USD"},
+			calls:     []taskCall{{method: "UpdateTask", id: 1, status: model.STATUS_SUCCESS}},
+		},
+		{
+			name:     "lease lost on retry",
+			tasks:    []model.Task{{ID: 1, Code: "EUR_USD", Attempts: 1}},
+			results:  map[string]quotafetcher.Result{},
+			retryErr: db.ErrorNotFound,
+			targets:  []string{"USD"},
+			calls:    []taskCall{{method: "RetryTask", id: 1, status: model.STATUS_PROCESSING, errorCode: model.ERROR_PROVIDER_UNAVAILABLE, delay: 30 * time.Second}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbmock := NewDbMock()
+			calls := recordTaskCalls(dbmock, tt.updateErr, tt.retryErr)
+			var requests []quotafetcher.BatchRequest
+			fetcher := &fetcherMock{fetchQuotas: func(ctx context.Context, req quotafetcher.BatchRequest) map[string]quotafetcher.Result {
+				requests = append(requests, req)
+				return tt.results
+			}}
+			w := NewWorker(dbmock, Config{Retry: testRetry}, zap.NewNop(), fetcher)
+
+			for i := range tt.tasks {
+				tt.tasks[i].Status = model.STATUS_PROCESSING
+			}
+			batches, _ := groupByBase(tt.tasks)
+			assert.Equal(t, len(batches), 1)
+			chanBatches := make(chan *quoteBatch, 1)
+			chanBatches <- batches[0]
+			close(chanBatches)
+			var wg sync.WaitGroup
+			wg.Add(1)
+			w.worker(context.Background(), chanBatches, &wg)
+
+			assert.Equal(t, calls(), tt.calls)
+			if tt.targets == nil {
+				assert.Equal(t, len(requests), 0)
+				return
+			}
+			assert.Equal(t, requests, []quotafetcher.BatchRequest{{Base: "EUR", Targets: tt.targets}})
+		})
+	}
+}
+
+func TestWorkerStoresResult(t *testing.T) {
+	price := decimal.RequireFromString("1.17")
+	day, _ := model.ParseDate("2025-08-15")
+	dbmock := NewDbMock()
+	var stored *model.Task
+	dbmock.updateTask = func(ctx context.Context, task *model.Task) (*model.Task, error) {
+		stored = task
+		return task, nil
+	}
+	fetcher := &fetcherMock{fetchQuotas: func(ctx context.Context, req quotafetcher.BatchRequest) map[string]quotafetcher.Result {
+		return map[string]quotafetcher.Result{"USD": {Price: price, Date: day, Provider: "ecb", Path: []string{"EUR", "USD"}}}
+	}}
+	w := NewWorker(dbmock, Config{Retry: testRetry}, zap.NewNop(), fetcher)
+
+	lastError := "connection reset"
+	errorCode := model.ERROR_PROVIDER_UNAVAILABLE
+	tasks := []model.Task{{ID: 1, Code: "EUR_USD", Status: model.STATUS_PROCESSING, Attempts: 2, LastError: &lastError, ErrorCode: &errorCode}}
+	batches, _ := groupByBase(tasks)
+	chanBatches := make(chan *quoteBatch, 1)
+	chanBatches <- batches[0]
+	close(chanBatches)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	w.worker(context.Background(), chanBatches, &wg)
+
+	assert.Equal(t, stored.Status, model.STATUS_SUCCESS)
+	assert.Equal(t, stored.Price.String(), "1.17")
+	assert.Equal(t, *stored.RateDate, day)
+	assert.Equal(t, *stored.Provider, "ecb")
+	assert.Equal(t, stored.ConversionPath, []string{"EUR", "USD"})
+	// The failure of the previous attempt is cleared
+	assert.Equal(t, stored.LastError, (*string)(nil))
+	assert.Equal(t, stored.ErrorCode, (*string)(nil))
+}
+
+func TestDoWorkStopsDispatchOnShutdown(t *testing.T) {
+	price := decimal.RequireFromString("1.17")
+	dbmock := NewDbMock()
+	calls := recordTaskCalls(dbmock, nil, nil)
+	dbmock.claimTasks = func(ctx context.Context, workerId string, limit int, lease time.Duration) ([]model.Task, error) {
+		return []model.Task{
+			{ID: 1, Code: "EUR_USD", Status: model.STATUS_PROCESSING, Attempts: 1},
+			{ID: 2, Code: "USD_JPY", Status: model.STATUS_PROCESSING, Attempts: 1},
+			{ID: 3, Code: "GBP_CHF", Status: model.STATUS_PROCESSING, Attempts: 1},
+		}, nil
+	}
+	fetching := make(chan struct{})
+	finish := make(chan struct{})
+	var mu sync.Mutex
+	var bases []string
+	fetcher := &fetcherMock{fetchQuotas: func(ctx context.Context, req quotafetcher.BatchRequest) map[string]quotafetcher.Result {
+		mu.Lock()
+		bases = append(bases, req.Base)
+		mu.Unlock()
+		fetching <- struct{}{}
+		<-finish
+		return map[string]quotafetcher.Result{req.Targets[0]: {Price: price}}
+	}}
+	w := NewWorker(dbmock, Config{
+		ID:                  "worker-1",
+		NumWorkers:          1,
+		LeaseDuration:       time.Minute,
+		Retry:               testRetry,
+		ShutdownGracePeriod: time.Minute,
+	}, zap.NewNop(), fetcher)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.doWork(ctx)
+		close(done)
+	}()
+	<-fetching
+	cancel()
+	// The next batch can't be handed over while the only goroutine is busy,
+	// so shutdown is noticed before anything else is dispatched
+	time.Sleep(20 * time.Millisecond)
+	close(finish)
+	<-done
+
+	assert.Equal(t, bases, []string{"EUR"})
+	assert.Equal(t, calls(), []taskCall{{method: "UpdateTask", id: 1, status: model.STATUS_SUCCESS}})
+}
+
+func TestDoWorkGracePeriod(t *testing.T) {
+	dbmock := NewDbMock()
+	calls := recordTaskCalls(dbmock, nil, nil)
+	dbmock.claimTasks = func(ctx context.Context, workerId string, limit int, lease time.Duration) ([]model.Task, error) {
+		return []model.Task{{ID: 1, Code: "EUR_USD", Status: model.STATUS_PROCESSING, Attempts: 1}}, nil
+	}
+	fetching := make(chan struct{})
+	fetcher := &fetcherMock{fetchQuotas: func(ctx context.Context, req quotafetcher.BatchRequest) map[string]quotafetcher.Result {
+		close(fetching)
+		// A provider that hangs until the request is canceled
+		<-ctx.Done()
+		return map[string]quotafetcher.Result{"USD": {Err: ctx.Err()}}
+	}}
+	const grace = 50 * time.Millisecond
+	w := NewWorker(dbmock, Config{
+		ID:                  "worker-1",
+		NumWorkers:          1,
+		LeaseDuration:       time.Minute,
+		Retry:               testRetry,
+		ShutdownGracePeriod: grace,
+	}, zap.NewNop(), fetcher)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.doWork(ctx)
+		close(done)
+	}()
+	<-fetching
+	canceledAt := time.Now()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("in-flight batch outlived the grace period")
+	}
+
+	assert.Equal(t, time.Since(canceledAt) >= grace, true)
+	// The interrupted batch is left to ReleaseTasks rather than retried
+	assert.Equal(t, len(calls()), 0)
+}
+
+func TestStartReleasesClaimsOnShutdown(t *testing.T) {
+	price := decimal.RequireFromString("1.17")
+	dbmock := NewDbMock()
+	var mu sync.Mutex
+	var events []string
+	event := func(name string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, name)
+	}
+	var claimed bool
+	dbmock.claimTasks = func(ctx context.Context, workerId string, limit int, lease time.Duration) ([]model.Task, error) {
+		if claimed {
+			return nil, nil
+		}
+		claimed = true
+		return []model.Task{{ID: 1, Code: "EUR_USD", Status: model.STATUS_PROCESSING, Attempts: 1}}, nil
+	}
+	dbmock.updateTask = func(ctx context.Context, task *model.Task) (*model.Task, error) {
+		event("UpdateTask " + task.Status)
+		return task, nil
+	}
+	dbmock.releaseTasks = func(ctx context.Context, workerId string) (int64, error) {
+		event("ReleaseTasks " + workerId)
+		return 0, nil
+	}
+	fetching := make(chan struct{})
+	finish := make(chan struct{})
+	fetcher := &fetcherMock{fetchQuotas: func(ctx context.Context, req quotafetcher.BatchRequest) map[string]quotafetcher.Result {
+		close(fetching)
+		<-finish
+		return map[string]quotafetcher.Result{"USD": {Price: price}}
+	}}
+	w := NewWorker(dbmock, Config{
+		ID:                  "worker-1",
+		Tick:                10 * time.Millisecond,
+		NumWorkers:          1,
+		LeaseDuration:       time.Minute,
+		ReapInterval:        10 * time.Millisecond,
+		Retry:               testRetry,
+		ShutdownGracePeriod: time.Minute,
+	}, zap.NewNop(), fetcher)
+
+	done := make(chan struct{})
+	dbmock.releaseExpiredTasks = func(ctx context.Context) (int64, error) {
+		select {
+		case <-done:
+			t.Error("reaper outlived the worker")
+		default:
+		}
+		return 0, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		w.Start(ctx)
+		close(done)
+	}()
+	<-fetching
+	cancel()
+	close(finish)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("worker did not stop")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, events, []string{"UpdateTask success", "ReleaseTasks worker-1"})
+}
+
+// memQueue keeps tasks the way the quotes table does: claiming counts an
+// attempt and leases the task, releasing an expired lease doesn't undo it.
+type memQueue struct {
+	mu    sync.Mutex
+	now   time.Time
+	tasks []model.Task
+}
+
+func (q *memQueue) mock() *dbMock {
+	dbmock := NewDbMock()
+	dbmock.claimTasks = func(ctx context.Context, workerId string, limit int, lease time.Duration) ([]model.Task, error) {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		var claimed []model.Task
+		for i := range q.tasks {
+			task := &q.tasks[i]
+			if task.Status != model.STATUS_PENDING || len(claimed) == limit {
+				continue
+			}
+			expiresAt := q.now.Add(lease)
+			task.Status = model.STATUS_PROCESSING
+			task.ClaimedBy = &workerId
+			task.LeaseExpiresAt = &expiresAt
+			task.Attempts++
+			claimed = append(claimed, *task)
+		}
+		return claimed, nil
+	}
+	dbmock.releaseExpiredTasks = func(ctx context.Context) (int64, error) {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		var released int64
+		for i := range q.tasks {
+			task := &q.tasks[i]
+			if task.Status == model.STATUS_PROCESSING && task.LeaseExpiresAt.Before(q.now) {
+				task.Status = model.STATUS_PENDING
+				task.ClaimedBy = nil
+				task.LeaseExpiresAt = nil
+				released++
+			}
+		}
+		return released, nil
+	}
+	dbmock.updateTask = func(ctx context.Context, update *model.Task) (*model.Task, error) {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		for i := range q.tasks {
+			task := &q.tasks[i]
+			if task.ID == update.ID && task.Status == model.STATUS_PROCESSING && *task.ClaimedBy == *update.ClaimedBy {
+				task.Status = update.Status
+				task.ErrorCode = update.ErrorCode
+				task.ClaimedBy = nil
+				task.LeaseExpiresAt = nil
+				return task, nil
+			}
+		}
+		return nil, db.ErrorNotFound
+	}
+	return dbmock
+}
+
+func (q *memQueue) task() model.Task {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.tasks[0]
+}
+
+func TestReapReturnsExpiredLeases(t *testing.T) {
+	const lease = time.Minute
+	queue := &memQueue{
+		now:   time.Date(2025, 8, 17, 19, 0, 0, 0, time.UTC),
+		tasks: []model.Task{{ID: 1, Code: "EUR_USD", Status: model.STATUS_PENDING}},
+	}
+	dbmock := queue.mock()
+	var fetches int
+	fetcher := &fetcherMock{fetchQuotas: func(ctx context.Context, req quotafetcher.BatchRequest) map[string]quotafetcher.Result {
+		fetches++
+		return nil
+	}}
+	w := NewWorker(dbmock, Config{
+		ID:            "worker-1",
+		NumWorkers:    1,
+		BatchSize:     10,
+		LeaseDuration: lease,
+		ReapInterval:  time.Second,
+		Retry:         testRetry,
+	}, zap.NewNop(), fetcher)
+	ctx := context.Background()
+
+	// Another replica claims the task and crashes, every time
+	for attempt := 1; attempt <= testRetry.MaxAttempts; attempt++ {
+		if _, err := dbmock.ClaimTasks(ctx, "worker-2", 10, lease); err != nil {
+			t.Fatal(err)
+		}
+		w.reap(ctx)
+		assert.Equal(t, queue.task().Status, model.STATUS_PROCESSING)
+
+		queue.now = queue.now.Add(lease + time.Second)
+		w.reap(ctx)
+		task := queue.task()
+		assert.Equal(t, task.Status, model.STATUS_PENDING)
+		assert.Equal(t, task.ClaimedBy, (*string)(nil))
+		assert.Equal(t, task.Attempts, attempt)
+	}
+
+	// The next claim is one attempt too many, so the task isn't fetched again
+	w.doWork(ctx)
+	task := queue.task()
+	assert.Equal(t, fetches, 0)
+	assert.Equal(t, task.Attempts, testRetry.MaxAttempts+1)
+	assert.Equal(t, task.Status, model.STATUS_DEAD)
+	assert.Equal(t, *task.ErrorCode, model.ERROR_ATTEMPTS_EXHAUSTED)
+}
+
+func TestStartWakesUp(t *testing.T) {
+	tests := []struct {
+		name string
+		tick time.Duration
+		// subscribe is handed the channel the test notifies on
+		subscribe func(notify chan struct{}) (<-chan struct{}, error)
+		notify    bool
+	}{
+		{
+			name: "notification before tick",
+			tick: time.Hour,
+			subscribe: func(notify chan struct{}) (<-chan struct{}, error) {
+				return notify, nil
+			},
+			notify: true,
+		},
+		{
+			name: "polling when listen fails",
+			tick: 10 * time.Millisecond,
+			subscribe: func(notify chan struct{}) (<-chan struct{}, error) {
+				return nil, errors.New("connection refused")
+			},
+		},
+		{
+			name: "polling when listener stops",
+			tick: 10 * time.Millisecond,
+			subscribe: func(notify chan struct{}) (<-chan struct{}, error) {
+				close(notify)
+				return notify, nil
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbmock := NewDbMock()
+			notify := make(chan struct{})
+			dbmock.subscribeNewTasks = func(ctx context.Context) (<-chan struct{}, error) {
+				return tt.subscribe(notify)
+			}
+			claimed := make(chan struct{}, 1)
+			dbmock.claimTasks = func(ctx context.Context, workerId string, limit int, lease time.Duration) ([]model.Task, error) {
+				select {
+				case claimed <- struct{}{}:
+				default:
+				}
+				return nil, nil
+			}
+			w := NewWorker(dbmock, Config{
+				ID:            "worker-1",
+				Tick:          tt.tick,
+				NumWorkers:    1,
+				LeaseDuration: time.Minute,
+				ReapInterval:  time.Hour,
+				Retry:         testRetry,
+			}, zap.NewNop(), &fetcherMock{})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				w.Start(ctx)
+				close(done)
+			}()
+			defer func() {
+				cancel()
+				<-done
+			}()
+			if tt.notify {
+				notify <- struct{}{}
+			}
+			select {
+			case <-claimed:
+			case <-time.After(time.Second):
+				t.Fatal("worker did not claim tasks")
+			}
+		})
+	}
+}
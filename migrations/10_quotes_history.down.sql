@@ -0,0 +1 @@
+DROP INDEX IF EXISTS quotes_code_updated_at_id;
@@ -0,0 +1,2 @@
+-- История котировок листается по ключу (updated_at, id) внутри пары
+CREATE INDEX quotes_code_updated_at_id ON quotes(code, updated_at, id);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS quotes_code_rate_date;
+DROP INDEX IF EXISTS quotes_code_as_of;
+
+ALTER TABLE quotes
+    DROP COLUMN IF EXISTS rate_date,
+    DROP COLUMN IF EXISTS as_of;
@@ -0,0 +1,8 @@
+-- Исторические котировки: as_of — день, на который запрошен курс (NULL для текущего),
+-- rate_date — день, на который курс действует по данным провайдера
+ALTER TABLE quotes
+    ADD COLUMN as_of DATE,
+    ADD COLUMN rate_date DATE;
+
+CREATE INDEX quotes_code_as_of ON quotes(code, as_of) WHERE status = 'success';
+CREATE INDEX quotes_code_rate_date ON quotes(code, rate_date) WHERE status = 'success';
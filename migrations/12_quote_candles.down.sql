@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS quotes_success_updated_at;
+DROP TABLE IF EXISTS quote_candles;
@@ -0,0 +1,16 @@
+-- Свечи (OHLC) по успешным котировкам, воркер досчитывает их по мере поступления котировок.
+-- Свечи до последней посчитанной отдаются из таблицы, остальные считаются на лету по quotes
+CREATE TABLE quote_candles (
+    code TEXT NOT NULL,
+    -- Ширина свечи, например 1h
+    period TEXT NOT NULL,
+    bucket TIMESTAMP NOT NULL,
+    open NUMERIC(25, 15) NOT NULL,
+    high NUMERIC(25, 15) NOT NULL,
+    low NUMERIC(25, 15) NOT NULL,
+    close NUMERIC(25, 15) NOT NULL,
+    count INTEGER NOT NULL,
+    PRIMARY KEY (code, period, bucket)
+);
+
+CREATE INDEX quotes_success_updated_at ON quotes(updated_at) WHERE status = 'success';
@@ -0,0 +1,20 @@
+DROP INDEX IF EXISTS quotes_pending_created_at;
+
+UPDATE quotes SET status = 'pending' WHERE status = 'processing';
+
+ALTER TABLE quotes
+    DROP COLUMN IF EXISTS claimed_by,
+    DROP COLUMN IF EXISTS lease_expires_at;
+
+-- Значение из enum удалить нельзя, поэтому пересоздаём тип
+ALTER TYPE quote_status RENAME TO quote_status_old;
+CREATE TYPE quote_status AS ENUM (
+    'pending',
+    'success',
+    'failed'
+);
+ALTER TABLE quotes
+    ALTER COLUMN status DROP DEFAULT,
+    ALTER COLUMN status TYPE quote_status USING status::text::quote_status,
+    ALTER COLUMN status SET DEFAULT 'pending';
+DROP TYPE quote_status_old;
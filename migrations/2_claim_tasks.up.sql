@@ -0,0 +1,9 @@
+-- Задачи захватываются воркером атомарно (FOR UPDATE SKIP LOCKED)
+-- и арендуются им до lease_expires_at
+ALTER TYPE quote_status ADD VALUE IF NOT EXISTS 'processing';
+
+ALTER TABLE quotes
+    ADD COLUMN claimed_by TEXT,
+    ADD COLUMN lease_expires_at TIMESTAMP;
+
+CREATE INDEX quotes_pending_created_at ON quotes(created_at) WHERE status = 'pending';
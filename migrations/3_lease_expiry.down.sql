@@ -0,0 +1 @@
+DROP INDEX IF EXISTS quotes_processing_lease_expires_at;
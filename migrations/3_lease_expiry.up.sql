@@ -0,0 +1 @@
+CREATE INDEX quotes_processing_lease_expires_at ON quotes(lease_expires_at) WHERE status = 'processing';
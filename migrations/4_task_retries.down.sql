@@ -0,0 +1,24 @@
+UPDATE quotes SET status = 'failed' WHERE status = 'dead';
+
+ALTER TABLE quotes
+    DROP COLUMN IF EXISTS attempts,
+    DROP COLUMN IF EXISTS last_error,
+    DROP COLUMN IF EXISTS next_attempt_at;
+
+-- Значение из enum удалить нельзя, поэтому пересоздаём тип
+DROP INDEX IF EXISTS quotes_pending_created_at;
+DROP INDEX IF EXISTS quotes_processing_lease_expires_at;
+ALTER TYPE quote_status RENAME TO quote_status_old;
+CREATE TYPE quote_status AS ENUM (
+    'pending',
+    'processing',
+    'success',
+    'failed'
+);
+ALTER TABLE quotes
+    ALTER COLUMN status DROP DEFAULT,
+    ALTER COLUMN status TYPE quote_status USING status::text::quote_status,
+    ALTER COLUMN status SET DEFAULT 'pending';
+DROP TYPE quote_status_old;
+CREATE INDEX quotes_pending_created_at ON quotes(created_at) WHERE status = 'pending';
+CREATE INDEX quotes_processing_lease_expires_at ON quotes(lease_expires_at) WHERE status = 'processing';
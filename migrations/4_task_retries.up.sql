@@ -0,0 +1,8 @@
+-- Ретраи на уровне очереди: после неудачи задача возвращается в pending
+-- до next_attempt_at, а после исчерпания попыток переходит в dead
+ALTER TYPE quote_status ADD VALUE IF NOT EXISTS 'dead';
+
+ALTER TABLE quotes
+    ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN last_error TEXT,
+    ADD COLUMN next_attempt_at TIMESTAMP;
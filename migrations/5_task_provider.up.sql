@@ -0,0 +1,2 @@
+-- Провайдер, вернувший котировку
+ALTER TABLE quotes ADD COLUMN provider TEXT;
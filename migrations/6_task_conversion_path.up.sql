@@ -0,0 +1,2 @@
+-- Цепочка валют, через которую посчитан кросс-курс (NULL для прямой котировки)
+ALTER TABLE quotes ADD COLUMN conversion_path TEXT[];
@@ -0,0 +1,2 @@
+-- Котировки отдельных провайдеров, из которых получена консенсусная цена
+ALTER TABLE quotes ADD COLUMN contributions JSONB;
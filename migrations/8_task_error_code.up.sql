@@ -0,0 +1,2 @@
+-- Машиночитаемая причина последней ошибки задачи, сообщение хранится в last_error
+ALTER TABLE quotes ADD COLUMN error_code TEXT;
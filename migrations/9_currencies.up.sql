@@ -0,0 +1,10 @@
+-- Каталог поддерживаемых валют, воркер периодически перезаписывает его по спискам провайдеров
+CREATE TABLE currencies (
+    code VARCHAR(3) PRIMARY KEY,
+    name TEXT NOT NULL,
+    -- NULL для валют без дробной части, например золота
+    minor_units INTEGER,
+    -- Провайдеры, котирующие валюту как базовую и как котируемую
+    base_providers TEXT[] NOT NULL DEFAULT '{}',
+    target_providers TEXT[] NOT NULL DEFAULT '{}'
+);